@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrNoEndpoints = errors.New("balancer: no available endpoints")
+
+// Endpoint is a backend address the client can send calls to.
+type Endpoint struct {
+	Addr     string
+	Weight   int // relative weight, <= 0 means 1
+	Metadata map[string]string
+}
+
+func (e Endpoint) weight() int {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// PickInfo describes the call a balancer is picking an endpoint for.
+type PickInfo struct {
+	Method   string
+	Args     any
+	Metadata map[string]string
+	Key      string // caller-supplied routing key, used by hash based balancers
+}
+
+// DoneFunc is called once the picked call has finished.
+type DoneFunc func(err error)
+
+func noopDone(error) {}
+
+// Balancer spreads calls across a set of endpoints. Implementations must be
+// safe for concurrent use; Update may be called at any time with the current
+// set of healthy endpoints.
+type Balancer interface {
+	Update(endpoints []Endpoint)
+	Pick(info PickInfo) (Endpoint, DoneFunc, error)
+}
+
+// endpointSet is the copy-on-write endpoint list shared by the balancers.
+type endpointSet struct {
+	mu        sync.RWMutex
+	endpoints []Endpoint
+}
+
+func (s *endpointSet) Update(endpoints []Endpoint) {
+	eps := make([]Endpoint, len(endpoints))
+	copy(eps, endpoints)
+
+	s.mu.Lock()
+	s.endpoints = eps
+	s.mu.Unlock()
+}
+
+func (s *endpointSet) list() []Endpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.endpoints
+}
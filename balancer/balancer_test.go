@@ -0,0 +1,111 @@
+package balancer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRoundRobin(t *testing.T) {
+	b := NewRoundRobin()
+	b.Update([]Endpoint{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}})
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		ep, done, err := b.Pick(PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(nil)
+		got = append(got, ep.Addr)
+	}
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("picks = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	b := NewWeighted()
+	b.Update([]Endpoint{{Addr: "a", Weight: 5}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}})
+
+	counts := map[string]int{}
+	for i := 0; i < 70; i++ {
+		ep, _, err := b.Pick(PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[ep.Addr]++
+	}
+	if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Fatalf("counts = %v, want a:50 b:10 c:10", counts)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	b := NewLeastOutstanding()
+	b.Update([]Endpoint{{Addr: "a"}, {Addr: "b"}})
+
+	first, done, _ := b.Pick(PickInfo{})
+	second, _, _ := b.Pick(PickInfo{})
+	if first.Addr == second.Addr {
+		t.Fatalf("both picks went to %s while the other endpoint was idle", first.Addr)
+	}
+	done(nil)
+	third, _, _ := b.Pick(PickInfo{})
+	if third.Addr != first.Addr {
+		t.Fatalf("pick = %s, want released endpoint %s", third.Addr, first.Addr)
+	}
+}
+
+func TestNoEndpoints(t *testing.T) {
+	for name, b := range map[string]Balancer{
+		"round_robin":       NewRoundRobin(),
+		"random":            NewRandom(),
+		"least_outstanding": NewLeastOutstanding(),
+		"p2c":               NewP2C(),
+		"weighted":          NewWeighted(),
+	} {
+		if _, _, err := b.Pick(PickInfo{}); err != ErrNoEndpoints {
+			t.Errorf("%s: Pick() = %v, want ErrNoEndpoints", name, err)
+		}
+	}
+}
+
+func TestConsistentHashMinimalRemap(t *testing.T) {
+	b := NewConsistentHash(100, KeyFromMetadata("user"))
+	b.Update([]Endpoint{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}})
+
+	pick := func(key string) string {
+		ep, _, err := b.Pick(PickInfo{Metadata: map[string]string{"user": key}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ep.Addr
+	}
+
+	before := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		before[key] = pick(key)
+		if again := pick(key); again != before[key] {
+			t.Fatalf("key %s moved from %s to %s without membership change", key, before[key], again)
+		}
+	}
+
+	b.Update([]Endpoint{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}, {Addr: "d"}})
+	moved := 0
+	for key, addr := range before {
+		now := pick(key)
+		if now != addr {
+			if now != "d" {
+				t.Fatalf("key %s moved from %s to %s, only moves to the new endpoint are allowed", key, addr, now)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > len(before)/2 {
+		t.Fatalf("%d of %d keys moved after adding one endpoint", moved, len(before))
+	}
+}
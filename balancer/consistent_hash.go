@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultReplicas = 160
+
+// KeyFunc extracts the routing key of a call. An empty key means the call
+// has no affinity and may go to any endpoint.
+type KeyFunc func(info PickInfo) string
+
+// KeyFromMetadata routes by the value of the named request metadata field.
+func KeyFromMetadata(name string) KeyFunc {
+	return func(info PickInfo) string {
+		return info.Metadata[name]
+	}
+}
+
+type consistentHash struct {
+	replicas int
+	key      KeyFunc
+
+	mu     sync.RWMutex
+	hashes []uint64 // sorted virtual node hashes
+	nodes  map[uint64]Endpoint
+	eps    []Endpoint
+}
+
+// NewConsistentHash maps calls with the same key onto the same endpoint using a
+// hash ring with replicas virtual nodes per unit of Endpoint.Weight. PickInfo.Key
+// takes precedence over key, which may be nil. Only keys owned by an endpoint that
+// joins or leaves are remapped.
+func NewConsistentHash(replicas int, key KeyFunc) Balancer {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &consistentHash{
+		replicas: replicas,
+		key:      key,
+	}
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return mix64(h.Sum64())
+}
+
+// mix64 is the splitmix64 finalizer; plain FNV clusters badly for keys that differ only in a suffix.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (b *consistentHash) Update(endpoints []Endpoint) {
+	nodes := make(map[uint64]Endpoint)
+	hashes := make([]uint64, 0, len(endpoints)*b.replicas)
+	for _, ep := range endpoints {
+		n := b.replicas * ep.weight()
+		for i := 0; i < n; i++ {
+			h := hashKey(ep.Addr + "#" + strconv.Itoa(i))
+			if _, dup := nodes[h]; dup {
+				continue
+			}
+			nodes[h] = ep
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	eps := make([]Endpoint, len(endpoints))
+	copy(eps, endpoints)
+
+	b.mu.Lock()
+	b.hashes = hashes
+	b.nodes = nodes
+	b.eps = eps
+	b.mu.Unlock()
+}
+
+func (b *consistentHash) Pick(info PickInfo) (Endpoint, DoneFunc, error) {
+	key := info.Key
+	if key == "" && b.key != nil {
+		key = b.key(info)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.hashes) == 0 {
+		return Endpoint{}, nil, ErrNoEndpoints
+	}
+	if key == "" {
+		return b.eps[rand.Intn(len(b.eps))], noopDone, nil
+	}
+
+	h := hashKey(key)
+	idx := sort.Search(len(b.hashes), func(i int) bool { return b.hashes[i] >= h })
+	if idx == len(b.hashes) {
+		idx = 0
+	}
+	return b.nodes[b.hashes[idx]], noopDone, nil
+}
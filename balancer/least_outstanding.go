@@ -0,0 +1,105 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// outstanding tracks in-flight calls per endpoint address.
+type outstanding struct {
+	endpointSet
+
+	mu     sync.RWMutex
+	counts map[string]*atomic.Int64
+}
+
+func (o *outstanding) Update(endpoints []Endpoint) {
+	o.endpointSet.Update(endpoints)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	counts := make(map[string]*atomic.Int64, len(endpoints))
+	for _, ep := range endpoints {
+		if c, ok := o.counts[ep.Addr]; ok {
+			counts[ep.Addr] = c
+		} else {
+			counts[ep.Addr] = new(atomic.Int64)
+		}
+	}
+	o.counts = counts
+}
+
+func (o *outstanding) counter(addr string) *atomic.Int64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if c, ok := o.counts[addr]; ok {
+		return c
+	}
+	// endpoint removed concurrently, count into a throwaway counter
+	return new(atomic.Int64)
+}
+
+func (o *outstanding) acquire(ep Endpoint) (Endpoint, DoneFunc, error) {
+	c := o.counter(ep.Addr)
+	c.Add(1)
+	return ep, func(error) { c.Add(-1) }, nil
+}
+
+type leastOutstanding struct {
+	outstanding
+}
+
+// NewLeastOutstanding picks the endpoint with the fewest in-flight calls.
+func NewLeastOutstanding() Balancer {
+	return &leastOutstanding{}
+}
+
+func (b *leastOutstanding) Pick(PickInfo) (Endpoint, DoneFunc, error) {
+	eps := b.list()
+	if len(eps) == 0 {
+		return Endpoint{}, nil, ErrNoEndpoints
+	}
+
+	// start at a random offset so ties don't all land on the first endpoint
+	offset := rand.Intn(len(eps))
+	best, bestCount := -1, int64(0)
+	for i := range eps {
+		idx := (offset + i) % len(eps)
+		n := b.counter(eps[idx].Addr).Load()
+		if best < 0 || n < bestCount {
+			best, bestCount = idx, n
+		}
+	}
+	return b.acquire(eps[best])
+}
+
+type p2c struct {
+	outstanding
+}
+
+// NewP2C picks two endpoints at random and uses the one with fewer in-flight calls.
+func NewP2C() Balancer {
+	return &p2c{}
+}
+
+func (b *p2c) Pick(PickInfo) (Endpoint, DoneFunc, error) {
+	eps := b.list()
+	switch len(eps) {
+	case 0:
+		return Endpoint{}, nil, ErrNoEndpoints
+	case 1:
+		return b.acquire(eps[0])
+	}
+
+	i := rand.Intn(len(eps))
+	j := rand.Intn(len(eps) - 1)
+	if j >= i {
+		j++
+	}
+	a, c := eps[i], eps[j]
+	if b.counter(c.Addr).Load() < b.counter(a.Addr).Load() {
+		a = c
+	}
+	return b.acquire(a)
+}
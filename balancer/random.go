@@ -0,0 +1,20 @@
+package balancer
+
+import "math/rand"
+
+type random struct {
+	endpointSet
+}
+
+// NewRandom picks an endpoint uniformly at random.
+func NewRandom() Balancer {
+	return &random{}
+}
+
+func (b *random) Pick(PickInfo) (Endpoint, DoneFunc, error) {
+	eps := b.list()
+	if len(eps) == 0 {
+		return Endpoint{}, nil, ErrNoEndpoints
+	}
+	return eps[rand.Intn(len(eps))], noopDone, nil
+}
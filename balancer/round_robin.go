@@ -0,0 +1,22 @@
+package balancer
+
+import "sync/atomic"
+
+type roundRobin struct {
+	endpointSet
+	next atomic.Uint64
+}
+
+// NewRoundRobin picks endpoints in turn.
+func NewRoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(PickInfo) (Endpoint, DoneFunc, error) {
+	eps := b.list()
+	if len(eps) == 0 {
+		return Endpoint{}, nil, ErrNoEndpoints
+	}
+	n := b.next.Add(1) - 1
+	return eps[n%uint64(len(eps))], noopDone, nil
+}
@@ -0,0 +1,55 @@
+package balancer
+
+import "sync"
+
+// weighted implements smooth weighted round robin (as in nginx).
+type weighted struct {
+	mu    sync.Mutex
+	peers []*weightedPeer
+	total int
+}
+
+type weightedPeer struct {
+	ep      Endpoint
+	weight  int
+	current int
+}
+
+// NewWeighted picks endpoints in proportion to Endpoint.Weight.
+func NewWeighted() Balancer {
+	return &weighted{}
+}
+
+func (b *weighted) Update(endpoints []Endpoint) {
+	peers := make([]*weightedPeer, 0, len(endpoints))
+	total := 0
+	for _, ep := range endpoints {
+		w := ep.weight()
+		peers = append(peers, &weightedPeer{ep: ep, weight: w})
+		total += w
+	}
+
+	b.mu.Lock()
+	b.peers = peers
+	b.total = total
+	b.mu.Unlock()
+}
+
+func (b *weighted) Pick(PickInfo) (Endpoint, DoneFunc, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.peers) == 0 {
+		return Endpoint{}, nil, ErrNoEndpoints
+	}
+
+	var best *weightedPeer
+	for _, p := range b.peers {
+		p.current += p.weight
+		if best == nil || p.current > best.current {
+			best = p
+		}
+	}
+	best.current -= b.total
+	return best.ep, noopDone, nil
+}
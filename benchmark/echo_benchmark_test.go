@@ -1,38 +1,57 @@
 package benchmark
 
 import (
-	"os/exec"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"xxrpc/client"
 	"xxrpc/examples/simple/echo"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+	"xxrpc/server"
 )
 
-func setupEchoServer(tb *testing.B) *exec.Cmd {
+// setupEchoServer starts an echo server in process and returns the listener
+// clients dial. The server is stopped when the test ends.
+func setupEchoServer(tb testing.TB) *memconn.Listener {
 	tb.Helper()
 
-	cmd := exec.Command("go", "run", "xxrpc/examples/simple/server/.")
-	if err := cmd.Start(); err != nil {
-		tb.Fatalf("failed to start echo server: %v", err)
-	}
+	s := server.NewServer("memconn", registry.NewRegister())
+	s.Register(&echo.EchoService{})
 
-	time.Sleep(time.Second)
-	return cmd
+	ln := memconn.Listen(1 << 20)
+	go s.Serve(ln)
+	tb.Cleanup(func() { s.Stop() })
+	return ln
 }
 
-func stopEchoServer(cmd *exec.Cmd) {
-	if cmd != nil {
-		if err := cmd.Process.Kill(); err != nil {
-			cmd.Process.Release()
-		}
+func dialEcho(tb testing.TB, ln *memconn.Listener) *client.Client {
+	tb.Helper()
+
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext))
+	if err != nil {
+		tb.Fatalf("client dial error: %v", err)
+	}
+	return cli
+}
+
+func TestEcho(t *testing.T) {
+	ln := setupEchoServer(t)
+	cli := dialEcho(t, ln)
+	defer cli.Close()
+
+	resp, err := cli.Call("EchoService.SayHello", echo.SayHelloReq{Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "" || string(*resp.Data) != `{"Message":"Echo:hi"}` {
+		t.Fatalf("resp = %s, %q", *resp.Data, resp.Error)
 	}
 }
 
 func BenchmarkEcho(b *testing.B) {
-	cmd := setupEchoServer(b)
-	defer stopEchoServer(cmd)
+	ln := setupEchoServer(b)
 
 	req := echo.ComplexHelloReq{
 		Message:   "Hello",
@@ -70,10 +89,13 @@ func BenchmarkEcho(b *testing.B) {
 	start := time.Now()
 
 	b.RunParallel(func(p *testing.PB) {
-		cli, err := client.Dial(":8888")
+		// Fatal must not be called from RunParallel goroutines
+		cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext))
 		if err != nil {
-			b.Fatalf("client dial error: %v", err)
+			b.Errorf("client dial error: %v", err)
+			return
 		}
+		defer cli.Close()
 
 		for p.Next() {
 			begin := time.Now()
@@ -0,0 +1,481 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"xxrpc/balancer"
+	"xxrpc/discovery"
+	"xxrpc/health"
+	"xxrpc/protocol"
+)
+
+const (
+	defaultHealthInterval = 5 * time.Second
+	healthCheckTimeout    = time.Second
+	endpointDialTimeout   = 5 * time.Second
+)
+
+// HealthCheckFunc probes an endpoint; a non-nil error marks it unhealthy.
+type HealthCheckFunc func(addr string) error
+
+// TCPHealthCheck reports an endpoint healthy when a TCP connection can be opened within timeout.
+func TCPHealthCheck(timeout time.Duration) HealthCheckFunc {
+	return func(addr string) error {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// dialHealthCheck reports an endpoint healthy when a connection can be opened
+// within timeout the way opts dial it, i.e. through WithDialer if it is set.
+func dialHealthCheck(timeout time.Duration, opts []Option) HealthCheckFunc {
+	probe := &Client{}
+	for _, opt := range opts {
+		opt.Apply(probe)
+	}
+	return func(addr string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := probe.dial(ctx, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+type BalancedOption interface {
+	Apply(*BalancedClient)
+}
+
+type balancedOptionFunc func(*BalancedClient)
+
+func (f balancedOptionFunc) Apply(bc *BalancedClient) {
+	f(bc)
+}
+
+// WithBalancer sets the load balancing policy. Defaults to round robin.
+func WithBalancer(b balancer.Balancer) BalancedOption {
+	return balancedOptionFunc(func(bc *BalancedClient) {
+		bc.balancer = b
+	})
+}
+
+// WithHealthCheck probes every endpoint each interval and takes failing ones out of rotation.
+// Endpoints are checked every 5s by default, by opening a connection the way they are dialed (over
+// TCP unless WithClientOptions sets WithDialer); a negative interval disables checks, in which case
+// an endpoint whose connection broke stays out of rotation.
+func WithHealthCheck(interval time.Duration, check HealthCheckFunc) BalancedOption {
+	return balancedOptionFunc(func(bc *BalancedClient) {
+		bc.healthInterval = interval
+		bc.healthCheck = check
+	})
+}
+
+// WithHealthService watches the Health service of every connected endpoint and
+// takes an endpoint out of rotation while it reports service as anything but
+// SERVING, e.g. once it has begun shutting down. The empty name watches the
+// server as a whole. Endpoints without a Health service are treated as serving.
+func WithHealthService(service string) BalancedOption {
+	return balancedOptionFunc(func(bc *BalancedClient) {
+		bc.healthWatch = true
+		bc.healthService = service
+	})
+}
+
+// WithClientOptions sets the options used to dial every endpoint.
+func WithClientOptions(opts ...Option) BalancedOption {
+	return balancedOptionFunc(func(bc *BalancedClient) {
+		bc.clientOpts = append(bc.clientOpts, opts...)
+	})
+}
+
+type subConn struct {
+	ep         balancer.Endpoint
+	client     *Client // nil while unhealthy
+	healthy    bool
+	notServing bool // reported by the endpoint's Health service
+}
+
+func (sc *subConn) usable() bool {
+	return sc.healthy && !sc.notServing
+}
+
+// BalancedClient spreads calls across a set of endpoints using a balancer.Balancer.
+type BalancedClient struct {
+	balancer       balancer.Balancer
+	clientOpts     []Option
+	healthInterval time.Duration
+	healthCheck    HealthCheckFunc
+	healthWatch    bool
+	healthService  string
+
+	mu    sync.RWMutex
+	conns map[string]*subConn
+	addrs []string // endpoint order as supplied, which the balancer sees
+
+	refreshMu sync.Mutex // orders balancer updates
+
+	watcher discovery.Watcher // set by DialService
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewBalancedClient(endpoints []balancer.Endpoint, opts ...BalancedOption) *BalancedClient {
+	bc := &BalancedClient{
+		balancer:       balancer.NewRoundRobin(),
+		healthInterval: defaultHealthInterval,
+		conns:          make(map[string]*subConn),
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.Apply(bc)
+	}
+
+	// the first endpoints are waited for, so calls can be made right away
+	bc.update(endpoints).Wait()
+
+	if bc.healthInterval > 0 {
+		if bc.healthCheck == nil {
+			bc.healthCheck = dialHealthCheck(healthCheckTimeout, bc.clientOpts)
+		}
+		go bc.healthLoop()
+	}
+	return bc
+}
+
+// UpdateEndpoints replaces the endpoint set. Connections to removed endpoints
+// are closed. New endpoints are dialed in the background and join the rotation
+// once connected, so a slow endpoint does not hold up the update.
+func (bc *BalancedClient) UpdateEndpoints(endpoints []balancer.Endpoint) {
+	bc.update(endpoints)
+}
+
+// update replaces the endpoint set and returns a WaitGroup that is done once
+// the new endpoints have been dialed.
+func (bc *BalancedClient) update(endpoints []balancer.Endpoint) *sync.WaitGroup {
+	var added []string
+
+	bc.mu.Lock()
+	next := make(map[string]*subConn, len(endpoints))
+	addrs := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if _, dup := next[ep.Addr]; dup {
+			continue
+		}
+		addrs = append(addrs, ep.Addr)
+		if sc, ok := bc.conns[ep.Addr]; ok {
+			sc.ep = ep
+			next[ep.Addr] = sc
+			continue
+		}
+		next[ep.Addr] = &subConn{ep: ep}
+		added = append(added, ep.Addr)
+	}
+	for addr, sc := range bc.conns {
+		if _, ok := next[addr]; !ok && sc.client != nil {
+			sc.client.Close()
+		}
+	}
+	bc.conns = next
+	bc.addrs = addrs
+	bc.mu.Unlock()
+
+	bc.refresh()
+
+	var wg sync.WaitGroup
+	for _, addr := range added {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			bc.connect(addr)
+		}(addr)
+	}
+	return &wg
+}
+
+// connect dials a new endpoint and puts it into rotation. On failure it is
+// left unhealthy and the health check retries it.
+func (bc *BalancedClient) connect(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointDialTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-bc.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	cli, err := dialContext(ctx, addr, bc.clientOpts)
+	if err != nil {
+		return
+	}
+
+	bc.mu.Lock()
+	sc, ok := bc.conns[addr]
+	installed := ok && sc.client == nil && !bc.closed()
+	if installed {
+		bc.install(addr, sc, cli)
+	}
+	bc.mu.Unlock()
+	if !installed {
+		cli.Close()
+		return
+	}
+	bc.refresh()
+}
+
+// refresh hands the currently healthy endpoints to the balancer.
+func (bc *BalancedClient) refresh() {
+	bc.refreshMu.Lock()
+	defer bc.refreshMu.Unlock()
+
+	bc.mu.RLock()
+	healthy := make([]balancer.Endpoint, 0, len(bc.addrs))
+	for _, addr := range bc.addrs {
+		if sc := bc.conns[addr]; sc.usable() {
+			healthy = append(healthy, sc.ep)
+		}
+	}
+	bc.mu.RUnlock()
+
+	bc.balancer.Update(healthy)
+}
+
+func (bc *BalancedClient) Call(serviceMethod string, args any, opts ...CallOption) (*protocol.Response, error) {
+	cli, addr, done, err := bc.pick(serviceMethod, args, newCallOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cli.Call(serviceMethod, args, opts...)
+	done(err)
+	if err != nil && !cli.Healthy() {
+		bc.markUnhealthy(addr, cli)
+	}
+	return resp, err
+}
+
+// CallOneway sends a one-way request to an endpoint picked by the balancer.
+func (bc *BalancedClient) CallOneway(serviceMethod string, args any, opts ...CallOption) error {
+	cli, addr, done, err := bc.pick(serviceMethod, args, newCallOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	err = cli.CallOneway(serviceMethod, args, opts...)
+	done(err)
+	if err != nil && !cli.Healthy() {
+		bc.markUnhealthy(addr, cli)
+	}
+	return err
+}
+
+// pick asks the balancer for an endpoint and returns its client.
+func (bc *BalancedClient) pick(serviceMethod string, args any, co *callOptions) (*Client, string, balancer.DoneFunc, error) {
+	ep, done, err := bc.balancer.Pick(balancer.PickInfo{
+		Method:   serviceMethod,
+		Args:     args,
+		Metadata: co.metadata,
+		Key:      co.hashKey,
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	bc.mu.RLock()
+	var cli *Client
+	if sc, ok := bc.conns[ep.Addr]; ok && sc.usable() {
+		cli = sc.client
+	}
+	bc.mu.RUnlock()
+	if cli == nil {
+		done(balancer.ErrNoEndpoints)
+		return nil, "", nil, balancer.ErrNoEndpoints
+	}
+	return cli, ep.Addr, done, nil
+}
+
+func (bc *BalancedClient) markUnhealthy(addr string, cli *Client) {
+	bc.mu.Lock()
+	sc, ok := bc.conns[addr]
+	if !ok || sc.client != cli {
+		bc.mu.Unlock()
+		return
+	}
+	sc.healthy = false
+	sc.client = nil
+	bc.mu.Unlock()
+
+	cli.Close()
+	bc.refresh()
+}
+
+// install makes cli the connection of sc and starts watching it. Called with
+// bc.mu held.
+func (bc *BalancedClient) install(addr string, sc *subConn, cli *Client) {
+	sc.client = cli
+	sc.healthy = true
+	sc.notServing = false
+	go bc.watchConn(addr, cli)
+	if bc.healthWatch {
+		go bc.watchHealth(addr, cli)
+	}
+}
+
+// watchHealth follows the Health service of the endpoint behind cli until the
+// connection is replaced or closed.
+func (bc *BalancedClient) watchHealth(addr string, cli *Client) {
+	s, err := cli.WatchHealth(bc.healthService)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	for {
+		resp, err := s.Recv()
+		if err != nil {
+			// a broken connection is handled by watchConn; otherwise the
+			// server has no Health service and is assumed to be serving
+			bc.setServing(addr, cli, true)
+			return
+		}
+		bc.setServing(addr, cli, resp.Status == health.StatusServing)
+	}
+}
+
+func (bc *BalancedClient) setServing(addr string, cli *Client, serving bool) {
+	bc.mu.Lock()
+	sc, ok := bc.conns[addr]
+	if !ok || sc.client != cli || sc.notServing == !serving {
+		bc.mu.Unlock()
+		return
+	}
+	sc.notServing = !serving
+	bc.mu.Unlock()
+
+	bc.refresh()
+}
+
+// watchConn takes an endpoint out of rotation as soon as its connection
+// fails, without waiting for a call to hit the broken connection.
+func (bc *BalancedClient) watchConn(addr string, cli *Client) {
+	select {
+	case <-bc.done:
+	case <-cli.Done():
+		bc.markUnhealthy(addr, cli)
+	}
+}
+
+func (bc *BalancedClient) healthLoop() {
+	ticker := time.NewTicker(bc.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bc.done:
+			return
+		case <-ticker.C:
+			bc.checkAll()
+		}
+	}
+}
+
+func (bc *BalancedClient) checkAll() {
+	bc.mu.RLock()
+	addrs := make([]string, 0, len(bc.conns))
+	for addr := range bc.conns {
+		addrs = append(addrs, addr)
+	}
+	bc.mu.RUnlock()
+
+	changed := false
+	for _, addr := range addrs {
+		err := bc.healthCheck(addr)
+
+		var cli *Client
+		if err == nil {
+			// dial outside the lock, then install if still needed
+			bc.mu.RLock()
+			sc, ok := bc.conns[addr]
+			needDial := ok && sc.client == nil
+			bc.mu.RUnlock()
+			if needDial {
+				ctx, cancel := context.WithTimeout(context.Background(), endpointDialTimeout)
+				if cli, err = dialContext(ctx, addr, bc.clientOpts); err != nil {
+					cli = nil
+				}
+				cancel()
+			}
+		}
+
+		bc.mu.Lock()
+		sc, ok := bc.conns[addr]
+		if !ok {
+			bc.mu.Unlock()
+			if cli != nil {
+				cli.Close()
+			}
+			continue
+		}
+		switch {
+		case err != nil && sc.healthy:
+			sc.healthy = false
+			if sc.client != nil {
+				sc.client.Close()
+				sc.client = nil
+			}
+			changed = true
+		case err == nil && !sc.healthy:
+			if sc.client == nil && cli != nil && !bc.closed() {
+				bc.install(addr, sc, cli)
+				cli = nil
+			}
+			sc.healthy = sc.client != nil
+			changed = changed || sc.healthy
+		}
+		bc.mu.Unlock()
+		if cli != nil {
+			cli.Close()
+		}
+	}
+
+	if changed {
+		bc.refresh()
+	}
+}
+
+func (bc *BalancedClient) closed() bool {
+	select {
+	case <-bc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (bc *BalancedClient) Close() error {
+	bc.closeOnce.Do(func() {
+		close(bc.done)
+		if bc.watcher != nil {
+			bc.watcher.Stop()
+		}
+	})
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, sc := range bc.conns {
+		if sc.client != nil {
+			sc.client.Close()
+			sc.client = nil
+		}
+		sc.healthy = false
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"xxrpc/balancer"
+	"xxrpc/discovery"
+	"xxrpc/health"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+	"xxrpc/server"
+)
+
+// memNet runs in-process servers by address for BalancedClient tests.
+type memNet struct {
+	mu      sync.Mutex
+	servers map[string]*memServer
+}
+
+type memServer struct {
+	s  *server.Server
+	ln *memconn.Listener
+}
+
+func newMemNet(t *testing.T) *memNet {
+	n := &memNet{servers: make(map[string]*memServer)}
+	t.Cleanup(func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for _, ms := range n.servers {
+			ms.s.Stop()
+		}
+	})
+	return n
+}
+
+// start serves on addr an Echo.Say method that returns its params and an
+// Echo.Addr method that returns addr.
+func (n *memNet) start(addr string) *server.Server {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	reg.ServiceMethods["Echo.Addr"] = &registry.ServiceMethod{Handler: func([]byte) ([]byte, error) { return json.Marshal(addr) }}
+	s := server.NewServer(addr, reg)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+
+	n.mu.Lock()
+	n.servers[addr] = &memServer{s: s, ln: ln}
+	n.mu.Unlock()
+	return s
+}
+
+// kill stops the server on addr and drops its connections.
+func (n *memNet) kill(addr string) {
+	n.mu.Lock()
+	ms := n.servers[addr]
+	delete(n.servers, addr)
+	n.mu.Unlock()
+
+	ms.s.Stop()
+	for _, p := range ms.s.Peers() {
+		p.Close()
+	}
+}
+
+// dial connects to the server on addr. The address "hang" never answers.
+func (n *memNet) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if addr == "hang" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	n.mu.Lock()
+	ms, ok := n.servers[addr]
+	n.mu.Unlock()
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return ms.ln.DialContext(ctx, addr)
+}
+
+func (n *memNet) healthCheck(addr string) error {
+	conn, err := n.dial(context.Background(), addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestBalancedCallErrorKeepsEndpoint(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: "a"}},
+		WithHealthCheck(-1, nil), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+
+	// a call failing on its own must not take the shared connection down
+	if _, err := bc.Call("Echo.Say", make(chan int)); err == nil {
+		t.Fatal("unencodable args: expected an error")
+	}
+	resp, err := bc.Call("Echo.Say", "hi")
+	if err != nil || resp.Error != "" || string(*resp.Data) != `"hi"` {
+		t.Fatalf("call after failed call: %v, %+v", err, resp)
+	}
+}
+
+// servedBy makes calls through bc and returns the set of endpoints that answered.
+func servedBy(bc *BalancedClient, calls int) map[string]bool {
+	seen := make(map[string]bool)
+	for i := 0; i < calls; i++ {
+		resp, err := bc.Call("Echo.Addr", nil)
+		if err != nil || resp.Error != "" {
+			continue
+		}
+		var addr string
+		json.Unmarshal(*resp.Data, &addr)
+		seen[addr] = true
+	}
+	return seen
+}
+
+// waitServedBy waits until calls through bc reach exactly addrs.
+func waitServedBy(t *testing.T, bc *BalancedClient, addrs ...string) {
+	t.Helper()
+	want := fmt.Sprint(addrs)
+	var got string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		seen := servedBy(bc, 4*len(addrs))
+		var served []string
+		for _, addr := range []string{"a", "b", "c"} {
+			if seen[addr] {
+				served = append(served, addr)
+			}
+		}
+		if got = fmt.Sprint(served); got == want {
+			return
+		}
+	}
+	t.Fatalf("served by %s, want %s", got, want)
+}
+
+func TestBalancedHealthCheck(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	n.start("b")
+
+	var mu sync.Mutex
+	down := map[string]bool{}
+	check := func(addr string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[addr] {
+			return errors.New("down")
+		}
+		return n.healthCheck(addr)
+	}
+	setDown := func(addr string, v bool) {
+		mu.Lock()
+		down[addr] = v
+		mu.Unlock()
+	}
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: "a"}, {Addr: "b"}},
+		WithHealthCheck(10*time.Millisecond, check), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+	waitServedBy(t, bc, "a", "b")
+
+	// a failing check evicts an endpoint whose connection is fine
+	setDown("b", true)
+	waitServedBy(t, bc, "a")
+	setDown("b", false)
+	waitServedBy(t, bc, "a", "b")
+
+	// a restarted server is redialed once its check passes again
+	n.kill("a")
+	waitServedBy(t, bc, "b")
+	n.start("a")
+	waitServedBy(t, bc, "a", "b")
+}
+
+func TestBalancedUpdateEndpoints(t *testing.T) {
+	n := newMemNet(t)
+	a := n.start("a")
+	n.start("b")
+	n.start("c")
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: "a"}},
+		WithHealthCheck(-1, nil), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+	waitServedBy(t, bc, "a")
+
+	bc.UpdateEndpoints([]balancer.Endpoint{{Addr: "a"}, {Addr: "b"}})
+	waitServedBy(t, bc, "a", "b")
+
+	bc.UpdateEndpoints([]balancer.Endpoint{{Addr: "b"}, {Addr: "c"}})
+	waitServedBy(t, bc, "b", "c")
+	// the connection to the removed endpoint is closed
+	for deadline := time.Now().Add(time.Second); len(a.Peers()) > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection to removed endpoint still open")
+		}
+	}
+}
+
+func TestDialService(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	n.start("b")
+	mem := discovery.NewMemory()
+	mem.Register(discovery.Instance{Service: "Echo", Addr: "a"}, 0)
+
+	bc, err := DialService(mem, "Echo", WithHealthCheck(-1, nil), WithClientOptions(WithDialer(n.dial)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	waitServedBy(t, bc, "a")
+
+	mem.Register(discovery.Instance{Service: "Echo", Addr: "b"}, 0)
+	waitServedBy(t, bc, "a", "b")
+	mem.Deregister(discovery.Instance{Service: "Echo", Addr: "a"})
+	waitServedBy(t, bc, "b")
+}
+
+func TestBalancedHealthService(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	b := n.start("b")
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: "a"}, {Addr: "b"}},
+		WithHealthCheck(-1, nil), WithHealthService(""), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+	waitServedBy(t, bc, "a", "b")
+
+	// an endpoint that reports NOT_SERVING leaves rotation but stays connected
+	b.Health().Shutdown()
+	waitServedBy(t, bc, "a")
+	if len(b.Peers()) == 0 {
+		t.Fatal("connection to a not serving endpoint was closed")
+	}
+	b.Health().Resume()
+	waitServedBy(t, bc, "a", "b")
+
+	b.Health().SetServingStatus("", health.StatusNotServing)
+	waitServedBy(t, bc, "a")
+}
+
+func TestBalancedDefaultHealthCheckTCP(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	s := server.NewServer("127.0.0.1:0", reg)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Stop()
+
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: ln.Addr().String()}}, WithHealthCheck(10*time.Millisecond, nil))
+	defer bc.Close()
+	time.Sleep(50 * time.Millisecond) // several default probes
+	if _, err := bc.Call("Echo.Say", "hi"); err != nil {
+		t.Fatalf("call after health checks: %v", err)
+	}
+
+	s.Stop()
+	for _, p := range s.Peers() {
+		p.Close()
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, err := bc.Call("Echo.Say", "hi"); errors.Is(err, balancer.ErrNoEndpoints) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stopped endpoint stayed in rotation")
+		}
+	}
+}
+
+func TestBalancedUpdateEndpointsSlowDial(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	bc := NewBalancedClient(nil, WithHealthCheck(-1, nil), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+
+	start := time.Now()
+	bc.UpdateEndpoints([]balancer.Endpoint{{Addr: "hang"}, {Addr: "a"}})
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("UpdateEndpoints blocked for %v on an endpoint that does not answer", d)
+	}
+	// the hanging endpoint never enters rotation
+	waitServedBy(t, bc, "a")
+}
+
+// orderBalancer records the endpoints of every Update.
+type orderBalancer struct {
+	balancer.Balancer
+	mu      sync.Mutex
+	updates [][]string
+}
+
+func (b *orderBalancer) Update(endpoints []balancer.Endpoint) {
+	addrs := make([]string, len(endpoints))
+	for i, ep := range endpoints {
+		addrs[i] = ep.Addr
+	}
+	b.mu.Lock()
+	b.updates = append(b.updates, addrs)
+	b.mu.Unlock()
+	b.Balancer.Update(endpoints)
+}
+
+func TestBalancedEndpointOrder(t *testing.T) {
+	n := newMemNet(t)
+	supplied := []string{"c", "a", "b"}
+	var eps []balancer.Endpoint
+	for _, addr := range supplied {
+		n.start(addr)
+		eps = append(eps, balancer.Endpoint{Addr: addr})
+	}
+	b := &orderBalancer{Balancer: balancer.NewRoundRobin()}
+	bc := NewBalancedClient(eps, WithBalancer(b), WithHealthCheck(-1, nil), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+	for i := 0; i < 5; i++ {
+		bc.refresh()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, addrs := range b.updates {
+		// every update lists its endpoints in the order they were supplied
+		i := 0
+		for _, addr := range addrs {
+			for i < len(supplied) && supplied[i] != addr {
+				i++
+			}
+			if i == len(supplied) {
+				t.Fatalf("update %v is not in the supplied order %v", addrs, supplied)
+			}
+		}
+	}
+	if last := b.updates[len(b.updates)-1]; fmt.Sprint(last) != fmt.Sprint(supplied) {
+		t.Fatalf("last update = %v, want %v", last, supplied)
+	}
+}
+
+func TestBalancedDefaultHealthCheckDialer(t *testing.T) {
+	n := newMemNet(t)
+	n.start("a")
+	// no custom check: the default probe must go through the memconn dialer
+	bc := NewBalancedClient([]balancer.Endpoint{{Addr: "a"}},
+		WithHealthCheck(10*time.Millisecond, nil), WithClientOptions(WithDialer(n.dial)))
+	defer bc.Close()
+
+	time.Sleep(50 * time.Millisecond) // several default probes
+	waitServedBy(t, bc, "a")
+	n.kill("a")
+	waitServedBy(t, bc)
+	n.start("a")
+	waitServedBy(t, bc, "a")
+}
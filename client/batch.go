@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"xxrpc/protocol"
+)
+
+// Batch collects calls that are sent to the server in one frame and answered
+// in one response. A Batch is not safe for concurrent use.
+type Batch struct {
+	c     *Client
+	calls []*BatchCall
+
+	// Sequential makes the server run the calls one after another in the
+	// order they were added instead of in parallel. Parallel calls are
+	// limited by the server's pending request cap.
+	Sequential bool
+}
+
+// BatchCall is one call of a Batch. Resp and Error are set by Batch.Do with
+// the same meaning as the results of Client.Call.
+type BatchCall struct {
+	ServiceMethod string
+	Args          any
+	Resp          *protocol.Response
+	Error         error
+
+	ctx      context.Context
+	metadata map[string]string
+	breaker  *Breaker
+	gen      uint64 // breaker generation the call was admitted in
+	obs      *observation
+	reqBytes int
+}
+
+// Batch starts an empty batch on c.
+func (c *Client) Batch() *Batch {
+	return &Batch{c: c}
+}
+
+// Add queues a call. Its result is available once Do has returned.
+func (b *Batch) Add(serviceMethod string, args any, opts ...CallOption) *BatchCall {
+	co := newCallOptions(opts)
+	call := &BatchCall{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		ctx:           co.ctx,
+		metadata:      co.metadata,
+	}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Len returns the number of calls added.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Do sends every call added so far in a single round trip and waits for all
+// results. Calls rejected by an open circuit breaker are not sent and fail with
+// ErrCircuitOpen. If the batch as a whole fails, the error is returned and also
+// set on every call that was sent.
+func (b *Batch) Do() error {
+	c := b.c
+	batch := protocol.BatchRequest{Sequential: b.Sequential}
+	sent := make([]*BatchCall, 0, len(b.calls))
+	for _, call := range b.calls {
+		call.reqBytes = -1
+		call.obs, call.metadata = c.observe(call.ctx, call.ServiceMethod, call.metadata)
+		// encode first so that local encoding failures don't reach the breaker
+		payload, err := c.codec.Marshal(call.Args)
+		if err != nil {
+			call.obs.encodeFailed()
+			b.finish(call, nil, err)
+			continue
+		}
+		if c.breakers != nil {
+			call.breaker = c.breakers.get(c.addr, call.ServiceMethod)
+			gen, err := call.breaker.Allow()
+			if err != nil {
+				call.breaker = nil
+				b.finish(call, nil, err)
+				continue
+			}
+			call.gen = gen
+		}
+		call.reqBytes = len(payload)
+		batch.Requests = append(batch.Requests, protocol.Request{
+			Method:   call.ServiceMethod,
+			Params:   &payload,
+			Metadata: call.metadata,
+		})
+		sent = append(sent, call)
+	}
+	if len(sent) == 0 {
+		return nil
+	}
+
+	pc, err := c.conn.StartBatch(&batch)
+	if err == nil {
+		<-pc.Done
+		err = pc.Err
+	}
+	switch {
+	case err == nil && pc.Batch == nil:
+		err = errors.New(pc.Resp.Error)
+	case err == nil && len(pc.Batch.Responses) != len(sent):
+		err = errors.New("xxrpc: batch response does not match request")
+	}
+	if err != nil {
+		for _, call := range sent {
+			b.finish(call, nil, err)
+		}
+		return err
+	}
+
+	for i, call := range sent {
+		b.finish(call, &pc.Batch.Responses[i], nil)
+	}
+	return nil
+}
+
+func (b *Batch) finish(call *BatchCall, resp *protocol.Response, err error) {
+	call.Resp, call.Error = resp, err
+	if call.breaker != nil {
+		call.breaker.Done(call.gen, b.c.breakers.cfg.IsFailure(resp, err))
+		call.breaker = nil
+	}
+	call.obs.done(resp, err, call.reqBytes)
+}
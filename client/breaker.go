@@ -0,0 +1,275 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"xxrpc/protocol"
+)
+
+// ErrCircuitOpen is returned without touching the network while a breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+const breakerBuckets = 10
+
+type BreakerConfig struct {
+	// ConsecutiveFailures trips the breaker after this many failures in a row. 0 disables it.
+	ConsecutiveFailures int
+	// ErrorRate trips the breaker when failures/total within Window reaches it. 0 disables it.
+	ErrorRate float64
+	// MinRequests is the number of calls within Window before ErrorRate is evaluated.
+	MinRequests int
+	// Window is the rolling window used for ErrorRate.
+	Window time.Duration
+	// OpenTimeout is how long the breaker stays open before letting probes through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes allowed (and needed to close) in half-open state.
+	HalfOpenRequests int
+	// PerMethod keys breakers by target and service method instead of target only.
+	PerMethod bool
+	// IsFailure decides whether a call counts as a failure. Defaults to err != nil.
+	IsFailure func(resp *protocol.Response, err error) bool
+	// OnStateChange is called on every state transition.
+	OnStateChange func(key string, from, to State)
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ConsecutiveFailures: 5,
+		ErrorRate:           0.5,
+		MinRequests:         20,
+		Window:              10 * time.Second,
+		OpenTimeout:         5 * time.Second,
+		HalfOpenRequests:    1,
+	}
+}
+
+func (cfg BreakerConfig) withDefaults() BreakerConfig {
+	def := DefaultBreakerConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = def.OpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = def.HalfOpenRequests
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(_ *protocol.Response, err error) bool { return err != nil }
+	}
+	return cfg
+}
+
+type bucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// Breaker is a closed/open/half-open circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	key string
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	gen         uint64 // bumped on every state change
+	consecutive int
+	buckets     [breakerBuckets]bucket
+	openedAt    time.Time
+	probes      int // probes let through in half-open state
+	successes   int // successful probes in half-open state
+}
+
+func NewBreaker(key string, cfg BreakerConfig) *Breaker {
+	return &Breaker{
+		key: key,
+		cfg: cfg.withDefaults(),
+		now: time.Now,
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(b.now())
+	return b.state
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Done with the returned generation.
+func (b *Breaker) Allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.now())
+	switch b.state {
+	case StateOpen:
+		return 0, ErrCircuitOpen
+	case StateHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return 0, ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return b.gen, nil
+}
+
+// Done records the outcome of a call admitted by Allow in generation gen.
+// Results of calls admitted before the last state change are ignored, so a
+// slow call from the closed state is not taken for a half-open probe.
+func (b *Breaker) Done(gen uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.advance(now)
+	if gen != b.gen {
+		return
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		if failed {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+		return
+	}
+
+	bk := b.bucketAt(now)
+	bk.total++
+	if failed {
+		bk.failures++
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+		b.setState(StateOpen, now)
+		return
+	}
+	if b.cfg.ErrorRate > 0 {
+		total, failures := b.counts(now)
+		if total >= b.cfg.MinRequests && total > 0 && float64(failures)/float64(total) >= b.cfg.ErrorRate {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+// advance moves an open breaker to half-open once OpenTimeout has elapsed.
+func (b *Breaker) advance(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setState(to State, now time.Time) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.gen++
+	b.consecutive = 0
+	b.probes = 0
+	b.successes = 0
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.buckets = [breakerBuckets]bucket{}
+	}
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.key, from, to)
+	}
+}
+
+func (b *Breaker) bucketWidth() time.Duration {
+	w := b.cfg.Window / breakerBuckets
+	if w <= 0 {
+		w = time.Millisecond
+	}
+	return w
+}
+
+func (b *Breaker) bucketAt(now time.Time) *bucket {
+	width := b.bucketWidth()
+	start := now.Truncate(width)
+	bk := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bk.start.Equal(start) {
+		*bk = bucket{start: start}
+	}
+	return bk
+}
+
+func (b *Breaker) counts(now time.Time) (total, failures int) {
+	for i := range b.buckets {
+		bk := &b.buckets[i]
+		if now.Sub(bk.start) < b.cfg.Window {
+			total += bk.total
+			failures += bk.failures
+		}
+	}
+	return total, failures
+}
+
+// breakerGroup lazily creates one breaker per key.
+type breakerGroup struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func newBreakerGroup(cfg BreakerConfig) *breakerGroup {
+	return &breakerGroup{
+		cfg:      cfg.withDefaults(),
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+func (g *breakerGroup) get(target, serviceMethod string) *Breaker {
+	key := target
+	if g.cfg.PerMethod {
+		key = target + "/" + serviceMethod
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[key]
+	if !ok {
+		b = NewBreaker(key, g.cfg)
+		g.breakers[key] = b
+	}
+	return b
+}
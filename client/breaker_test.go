@@ -0,0 +1,105 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(cfg BreakerConfig) (*Breaker, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	b := NewBreaker("test", cfg)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	b, now := newTestBreaker(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Second})
+
+	for i := 0; i < 3; i++ {
+		gen, err := b.Allow()
+		if err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+		b.Done(gen, true)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("Allow() = %v, want ErrCircuitOpen", err)
+	}
+
+	*now = now.Add(time.Second)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("state = %v, want half-open", b.State())
+	}
+	gen, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("second probe = %v, want ErrCircuitOpen", err)
+	}
+	b.Done(gen, false)
+	if b.State() != StateClosed {
+		t.Fatalf("state = %v, want closed", b.State())
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b, now := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+
+	gen, _ := b.Allow()
+	b.Done(gen, true)
+	*now = now.Add(time.Second)
+	gen, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	b.Done(gen, true)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b, _ := newTestBreaker(BreakerConfig{ErrorRate: 0.5, MinRequests: 4, Window: time.Minute})
+
+	outcomes := []bool{false, true, false, true}
+	for i, failed := range outcomes {
+		gen, err := b.Allow()
+		if err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+		b.Done(gen, failed)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	b, now := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+
+	slow, _ := b.Allow()
+	failing, _ := b.Allow()
+	b.Done(failing, true)
+	*now = now.Add(time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+
+	// the slow call was admitted while closed and must not close the breaker
+	b.Done(slow, false)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("stale success moved the breaker to %v", b.State())
+	}
+	if _, err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("second probe = %v, want ErrCircuitOpen", err)
+	}
+	b.Done(probe, true)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v, want open after the probe failed", b.State())
+	}
+}
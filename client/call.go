@@ -0,0 +1,86 @@
+package client
+
+import (
+	"xxrpc/internal/transport"
+	"xxrpc/protocol"
+)
+
+// Call is an asynchronous call started by Client.Go.
+type Call struct {
+	ServiceMethod string
+	Args          any
+	Resp          *protocol.Response
+	Error         error
+	Done          chan *Call // receives the call once it has finished
+}
+
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+	default:
+		// the caller did not leave room in Done; as with net/rpc the result is dropped
+	}
+}
+
+// Go starts a call without waiting for the response. The call is sent to done
+// once it has finished; if done is nil a new channel is allocated. done must be
+// buffered with enough room for every call sharing it, Go panics if it is
+// unbuffered.
+func (c *Client) Go(serviceMethod string, args any, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		panic("xxrpc: done channel is unbuffered")
+	}
+	call := &Call{ServiceMethod: serviceMethod, Args: args, Done: done}
+	co := newCallOptions(opts)
+	o, md := c.observe(co.ctx, serviceMethod, co.metadata)
+
+	var cb *Breaker
+	var gen uint64
+	// encode first so that local encoding failures don't reach the breaker
+	payload, err := c.codec.Marshal(args)
+	finish := func(resp *protocol.Response, err error) {
+		call.Resp, call.Error = resp, err
+		if cb != nil {
+			cb.Done(gen, c.breakers.cfg.IsFailure(resp, err))
+		}
+		o.done(resp, err, len(payload))
+		call.done()
+	}
+	if err != nil {
+		o.encodeFailed()
+		finish(nil, err)
+		return call
+	}
+	if c.breakers != nil {
+		cb = c.breakers.get(c.addr, serviceMethod)
+		if gen, err = cb.Allow(); err != nil {
+			cb = nil
+			finish(nil, err)
+			return call
+		}
+	}
+	req := protocol.Request{
+		Method:   serviceMethod,
+		Params:   &payload,
+		Metadata: md,
+	}
+	pc, err := c.conn.StartCallFunc(&req, func(pc *transport.PendingCall) {
+		finish(pc.Resp, pc.Err)
+	})
+	if err != nil {
+		finish(nil, err)
+		return call
+	}
+	if ctxDone := co.done(); ctxDone != nil {
+		go func() {
+			select {
+			case <-pc.Done:
+			case <-ctxDone:
+				c.conn.CancelCall(pc, co.ctx.Err())
+			}
+		}()
+	}
+	return call
+}
@@ -1,53 +1,222 @@
 package client
 
 import (
+	"context"
+	"io"
 	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 
 	"xxrpc/internal/codec"
+	"xxrpc/internal/transport"
+	"xxrpc/metrics"
 	"xxrpc/protocol"
+	"xxrpc/registry"
+	"xxrpc/tracing"
 )
 
+// Client is a connection to one server. It is safe for concurrent use: calls
+// and streams are multiplexed over the same connection.
 type Client struct {
-	fc    *protocol.FrameConn
-	codec codec.Codec
+	addr     string
+	conn     *transport.Conn
+	codec    codec.Codec
+	breakers *breakerGroup
+
+	maxMessageSize int
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+
+	registry *registry.Registry // served to the server, may be nil
+	dialer   func(ctx context.Context, addr string) (net.Conn, error)
+
+	metricsReg prometheus.Registerer
+	metrics    *metrics.Metrics // nil unless WithMetrics is used
+	tracer     *tracing.Tracer  // nil unless WithTracerProvider is used
 }
 
-func Dial(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+// Dial connects to addr over TCP, or with the function set by WithDialer.
+func Dial(addr string, opts ...Option) (*Client, error) {
+	return dialContext(context.Background(), addr, opts)
+}
+
+func dialContext(ctx context.Context, addr string, opts []Option) (*Client, error) {
+	c, err := newClient(addr, opts)
 	if err != nil {
 		return nil, err
 	}
-	fc := protocol.NewFrameConn(conn)
-	return &Client{fc: fc, codec: &codec.JsoniterCodec{}}, nil
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.start(conn)
+	return c, nil
 }
 
-func (c *Client) Call(serviceMethod string, args any) (*protocol.Response, error) {
-	payload, _ := c.codec.Marshal(args)
-	req := protocol.Request{
-		Method: serviceMethod,
-		Params: &payload,
+// NewClient returns a client using an established connection, such as one
+// end of a memconn pipe. The client owns conn and closes it on Close.
+func NewClient(conn net.Conn, opts ...Option) (*Client, error) {
+	c, err := newClient(conn.RemoteAddr().String(), opts)
+	if err != nil {
+		return nil, err
+	}
+	c.start(conn)
+	return c, nil
+}
+
+func newClient(addr string, opts []Option) (*Client, error) {
+	c := &Client{addr: addr, codec: &codec.JsoniterCodec{}}
+	for _, opt := range opts {
+		opt.Apply(c)
 	}
+	if c.metricsReg != nil {
+		m, err := metrics.NewClient(c.metricsReg)
+		if err != nil {
+			return nil, err
+		}
+		c.metrics = m
+	}
+	return c, nil
+}
 
-	data, _ := c.codec.Marshal(req)
-	if err := c.fc.WriteFrame(data); err != nil {
-		return nil, err
+// dial opens a connection to addr with the dialer set by WithDialer, or over TCP.
+func (c *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if c.dialer != nil {
+		return c.dialer(ctx, addr)
 	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (c *Client) start(conn net.Conn) {
+	var handler transport.Handler
+	if c.registry != nil {
+		handler = registryHandler{c.registry}
+	}
+	c.conn = transport.NewConn(protocol.NewFrameConn(conn), c.codec, true, handler)
+	if c.maxMessageSize > 0 {
+		c.conn.MaxMessageSize = c.maxMessageSize
+	}
+	c.conn.PingInterval = c.pingInterval
+	c.conn.PingTimeout = c.pingTimeout
+	c.metrics.ConnOpened()
+	go c.serve()
+}
+
+func (c *Client) serve() {
+	err := c.conn.Serve()
+	c.metrics.ConnClosed()
+	// errors after the connection was closed for another reason are not read errors
+	if err != io.EOF && c.conn.Err() == err {
+		c.metrics.FrameError()
+	}
+}
 
-	buf, err := c.fc.ReadFrame()
+func (c *Client) Call(serviceMethod string, args any, opts ...CallOption) (*protocol.Response, error) {
+	co := newCallOptions(opts)
+	o, md := c.observe(co.ctx, serviceMethod, co.metadata)
+	co.metadata = md
+
+	payload, err := c.codec.Marshal(args)
+	var resp *protocol.Response
+	if err == nil {
+		resp, err = c.breakerCall(serviceMethod, payload, co)
+	} else {
+		o.encodeFailed()
+	}
+	o.done(resp, err, len(payload))
+	return resp, err
+}
+
+func (c *Client) breakerCall(serviceMethod string, payload []byte, co *callOptions) (*protocol.Response, error) {
+	if c.breakers == nil {
+		return c.call(serviceMethod, payload, co)
+	}
+
+	cb := c.breakers.get(c.addr, serviceMethod)
+	gen, err := cb.Allow()
 	if err != nil {
 		return nil, err
 	}
+	resp, err := c.call(serviceMethod, payload, co)
+	cb.Done(gen, c.breakers.cfg.IsFailure(resp, err))
+	return resp, err
+}
 
-	var resp protocol.Response
-	if err := c.codec.Unmarshal(buf, &resp); err != nil {
+// CallOneway sends a request that expects no response and returns as soon as
+// it has been written. Server-side failures are not reported to the caller.
+func (c *Client) CallOneway(serviceMethod string, args any, opts ...CallOption) error {
+	co := newCallOptions(opts)
+	o, md := c.observe(co.ctx, serviceMethod, co.metadata)
+	co.metadata = md
+	payload, err := c.codec.Marshal(args)
+	if err != nil {
+		o.encodeFailed()
+		o.done(nil, err, -1)
+		return err
+	}
+	var cb *Breaker
+	var gen uint64
+	if c.breakers != nil {
+		cb = c.breakers.get(c.addr, serviceMethod)
+		if gen, err = cb.Allow(); err != nil {
+			o.done(nil, err, -1)
+			return err
+		}
+	}
+
+	err = c.conn.SendOneway(&protocol.Request{
+		Method:   serviceMethod,
+		Params:   &payload,
+		Metadata: co.metadata,
+	})
+	if cb != nil {
+		cb.Done(gen, c.breakers.cfg.IsFailure(nil, err))
+	}
+	o.done(nil, err, len(payload))
+	return err
+}
+
+func (c *Client) call(serviceMethod string, payload []byte, co *callOptions) (*protocol.Response, error) {
+	req := protocol.Request{
+		Method:   serviceMethod,
+		Params:   &payload,
+		Metadata: co.metadata,
+	}
+
+	pc, err := c.conn.StartCall(&req)
+	if err != nil {
 		return nil, err
 	}
-	return &resp, nil
+	select {
+	case <-pc.Done:
+	case <-co.done():
+		c.conn.CancelCall(pc, co.ctx.Err())
+		<-pc.Done
+	}
+	return pc.Resp, pc.Err
+}
+
+// Codec returns the codec used to encode calls and decode their results.
+func (c *Client) Codec() codec.Codec {
+	return c.codec
+}
+
+// Healthy reports whether the connection is still usable.
+func (c *Client) Healthy() bool {
+	return c.conn.Err() == nil
+}
+
+// Done is closed when the connection has failed, e.g. because keepalive pings
+// went unanswered, or has been closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.conn.Done()
 }
 
 func (c *Client) Close() error {
-	if c.fc != nil {
-		return c.fc.Close()
+	if c.conn != nil {
+		return c.conn.Close()
 	}
 	return nil
 }
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"xxrpc/memconn"
+	"xxrpc/metrics"
+	"xxrpc/protocol"
+	"xxrpc/registry"
+	"xxrpc/server"
+)
+
+// serve runs a server for reg on a memconn listener and dials it with opts.
+func serve(t *testing.T, reg *registry.Registry, opts ...Option) *Client {
+	t.Helper()
+	s := server.NewServer("memconn", reg)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Stop() })
+
+	c, err := Dial("memconn", append([]Option{WithDialer(ln.DialContext)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// requestCounts returns xxrpc_client_requests_total by "method/code".
+func requestCounts(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "xxrpc_client_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var method, code string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "method":
+					method = l.GetValue()
+				case "code":
+					code = l.GetValue()
+				}
+			}
+			counts[method+"/"+code] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func TestClientMetrics(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	reg.ServiceMethods["Echo.Stream"] = &registry.ServiceMethod{StreamHandler: func(st registry.Stream) error {
+		return st.SendMsg("hi")
+	}}
+	reg.ServiceMethods["Echo.Fail"] = &registry.ServiceMethod{StreamHandler: func(registry.Stream) error {
+		return errors.New("boom")
+	}}
+	reg.ServiceMethods["Echo.Wait"] = &registry.ServiceMethod{StreamHandler: func(st registry.Stream) error {
+		var msg string
+		return st.RecvMsg(&msg)
+	}}
+	prom := prometheus.NewRegistry()
+	c := serve(t, reg, WithMetrics(prom))
+
+	if _, err := c.Call("Echo.Say", make(chan int)); err == nil {
+		t.Fatal("call with an unencodable argument succeeded")
+	}
+	for _, method := range []string{"Echo.Stream", "Echo.Fail"} {
+		st, err := c.NewStream(method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg string
+		for st.RecvMsg(&msg) == nil {
+		}
+	}
+	st, err := c.NewStream("Echo.Wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	var msg string
+	if err := st.RecvMsg(&msg); err == io.EOF {
+		t.Fatal("closed stream ended normally")
+	}
+
+	want := map[string]float64{
+		"Echo.Say/" + metrics.CodeEncodeError: 1,
+		"Echo.Stream/" + metrics.CodeOK:       1,
+		"Echo.Fail/" + metrics.CodeError:      1,
+		"Echo.Wait/" + metrics.CodeCanceled:   1,
+	}
+	// streams are recorded once they end, which is observed asynchronously
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		got := requestCounts(t, prom)
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("requests_total = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Block"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) {
+		<-release
+		return b, nil
+	}}
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	reg.ServiceMethods["Echo.Wait"] = &registry.ServiceMethod{StreamHandler: func(st registry.Stream) error {
+		var msg string
+		return st.RecvMsg(&msg)
+	}}
+	c := serve(t, reg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call("Echo.Block", "hi", WithContext(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call past its deadline = %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	call := c.Go("Echo.Block", "hi", make(chan *Call, 1), WithContext(ctx))
+	cancel()
+	select {
+	case <-call.Done:
+		if !errors.Is(call.Error, context.Canceled) {
+			t.Fatalf("canceled Go call = %v", call.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceling did not finish the Go call")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	st, err := c.NewStream("Echo.Wait", WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	var msg string
+	if err := st.RecvMsg(&msg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RecvMsg on a canceled stream = %v", err)
+	}
+
+	// the connection survives abandoned calls
+	if resp, err := c.Call("Echo.Say", "hi", WithContext(context.Background())); err != nil || resp.Error != "" {
+		t.Fatalf("call after cancellations = %+v, %v", resp, err)
+	}
+}
+
+// countingConn counts the writes made to the connection.
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestWithBreaker(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Fail"] = &registry.ServiceMethod{Handler: func([]byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}}
+	s := server.NewServer("memconn", reg)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	var writes atomic.Int64
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := ln.DialContext(ctx, addr)
+		return countingConn{conn, &writes}, err
+	}
+	c, err := Dial("memconn", WithDialer(dial), WithBreaker(BreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Hour,
+		IsFailure:           func(resp *protocol.Response, err error) bool { return err != nil || resp.Error != "" },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// local encoding failures are not the server's fault
+	<-c.Go("Echo.Fail", make(chan int), nil).Done
+	c.CallOneway("Echo.Fail", make(chan int))
+	for i := 0; i < 2; i++ {
+		if resp, err := c.Call("Echo.Fail", "hi"); err != nil || resp.Error == "" {
+			t.Fatalf("call %d = %+v, %v, want the handler's error", i, resp, err)
+		}
+	}
+
+	before := writes.Load()
+	if _, err := c.Call("Echo.Fail", "hi"); err != ErrCircuitOpen {
+		t.Fatalf("Call on an open breaker = %v", err)
+	}
+	if call := <-c.Go("Echo.Fail", "hi", make(chan *Call, 1)).Done; call.Error != ErrCircuitOpen {
+		t.Fatalf("Go on an open breaker = %v", call.Error)
+	}
+	if err := c.CallOneway("Echo.Fail", "hi"); err != ErrCircuitOpen {
+		t.Fatalf("CallOneway on an open breaker = %v", err)
+	}
+	if _, err := c.NewStream("Echo.Fail"); err != ErrCircuitOpen {
+		t.Fatalf("NewStream on an open breaker = %v", err)
+	}
+	if n := writes.Load() - before; n != 0 {
+		t.Fatalf("calls on an open breaker made %d writes", n)
+	}
+}
+
+func TestGo(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	c := serve(t, reg)
+
+	// calls sharing one channel are all delivered to it
+	done := make(chan *Call, 3)
+	calls := map[*Call]string{}
+	for _, msg := range []string{"a", "b", "c"} {
+		calls[c.Go("Echo.Say", msg, done)] = msg
+	}
+	for range calls {
+		call := <-done
+		msg, ok := calls[call]
+		if !ok {
+			t.Fatalf("unknown call %+v delivered", call)
+		}
+		if call.Error != nil || call.Resp.Error != "" || string(*call.Resp.Data) != `"`+msg+`"` {
+			t.Fatalf("call for %q = %+v, %v", msg, call.Resp, call.Error)
+		}
+	}
+
+	// a nil channel gets allocated, and failures arrive through it too
+	call := c.Go("Echo.Say", make(chan int), nil)
+	if got := <-call.Done; got != call || got.Error == nil {
+		t.Fatalf("unencodable call = %+v", got)
+	}
+	c.Close()
+	if call := <-c.Go("Echo.Say", "hi", nil).Done; call.Error == nil {
+		t.Fatal("call on a closed client succeeded")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Go with an unbuffered channel did not panic")
+		}
+	}()
+	c.Go("Echo.Say", "hi", make(chan *Call))
+}
+
+func TestBatch(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	reg.ServiceMethods["Echo.Fail"] = &registry.ServiceMethod{Handler: func([]byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}}
+	prom := prometheus.NewRegistry()
+	c := serve(t, reg, WithMetrics(prom), WithBreaker(BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Hour,
+		PerMethod:           true,
+		IsFailure:           func(resp *protocol.Response, err error) bool { return err != nil || resp.Error != "" },
+	}))
+
+	// every call gets its own result, in the order it was added
+	b := c.Batch()
+	a := b.Add("Echo.Say", "a")
+	fail := b.Add("Echo.Fail", "x")
+	unencodable := b.Add("Echo.Say", make(chan int))
+	last := b.Add("Echo.Say", "b")
+	if err := b.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Error != nil || string(*a.Resp.Data) != `"a"` || last.Error != nil || string(*last.Resp.Data) != `"b"` {
+		t.Fatalf("results = %+v, %+v", a, last)
+	}
+	if fail.Error != nil || fail.Resp.Error != "boom" {
+		t.Fatalf("failing call = %+v, %v", fail.Resp, fail.Error)
+	}
+	if unencodable.Error == nil || unencodable.Resp != nil {
+		t.Fatalf("unencodable call = %+v, %v", unencodable.Resp, unencodable.Error)
+	}
+
+	// the failure opened Echo.Fail's breaker, so that call is not sent again
+	b = c.Batch()
+	fail = b.Add("Echo.Fail", "x")
+	say := b.Add("Echo.Say", "c")
+	if err := b.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if fail.Error != ErrCircuitOpen || say.Error != nil || string(*say.Resp.Data) != `"c"` {
+		t.Fatalf("results with an open breaker = %v, %+v, %v", fail.Error, say.Resp, say.Error)
+	}
+
+	// a failed batch fails every call that was sent
+	c.Close()
+	b = c.Batch()
+	calls := []*BatchCall{b.Add("Echo.Say", "d"), b.Add("Echo.Say", "e")}
+	err := b.Do()
+	if err == nil {
+		t.Fatal("batch on a closed client succeeded")
+	}
+	for i, call := range calls {
+		if call.Error != err || call.Resp != nil {
+			t.Fatalf("call %d of a failed batch = %+v, %v, want %v", i, call.Resp, call.Error, err)
+		}
+	}
+
+	want := map[string]float64{
+		"Echo.Say/" + metrics.CodeOK:           3,
+		"Echo.Say/" + metrics.CodeEncodeError:  1,
+		"Echo.Say/" + metrics.CodeUnavailable:  2,
+		"Echo.Fail/" + metrics.CodeError:       1,
+		"Echo.Fail/" + metrics.CodeCircuitOpen: 1,
+	}
+	if got := requestCounts(t, prom); !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests_total = %v, want %v", got, want)
+	}
+}
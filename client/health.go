@@ -0,0 +1,40 @@
+package client
+
+import (
+	"errors"
+
+	"xxrpc/health"
+)
+
+// CheckHealth asks the server's Health service for the status of service. The
+// empty name asks about the server as a whole.
+func (c *Client) CheckHealth(service string, opts ...CallOption) (health.Status, error) {
+	resp, err := c.Call(health.ServiceName+".Check", &health.CheckReq{Service: service}, opts...)
+	if err != nil {
+		return health.StatusUnknown, err
+	}
+	if resp.Error != "" {
+		return health.StatusUnknown, errors.New(resp.Error)
+	}
+	var out health.CheckResp
+	if resp.Data != nil {
+		if err := c.codec.Unmarshal(*resp.Data, &out); err != nil {
+			return health.StatusUnknown, err
+		}
+	}
+	return out.Status, nil
+}
+
+// WatchHealth subscribes to the status of service. Recv on the returned stream
+// yields the current status first, then every change; Close ends the watch.
+func (c *Client) WatchHealth(service string, opts ...CallOption) (*BidiStream[health.CheckReq, health.CheckResp], error) {
+	s, err := OpenStream[health.CheckReq, health.CheckResp](c, health.ServiceName+".Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Send(&health.CheckReq{Service: service}); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"xxrpc/internal/transport"
+	"xxrpc/metrics"
+	"xxrpc/protocol"
+	"xxrpc/tracing"
+)
+
+// observation records one call in the client's metrics and traces.
+type observation struct {
+	c      *Client
+	method string
+	start  time.Time
+	span   *tracing.Span
+	code   string // overrides the code derived from the result if set
+}
+
+// observe starts recording a call to method. It returns md with the trace
+// context of the call's span added.
+func (c *Client) observe(ctx context.Context, method string, md map[string]string) (*observation, map[string]string) {
+	c.metrics.Start(method)
+	o := &observation{c: c, method: method, start: time.Now()}
+	o.span, md = c.tracer.StartClient(ctx, method, c.addr, md)
+	return o, md
+}
+
+// encodeFailed labels the call as failed to encode its request.
+func (o *observation) encodeFailed() {
+	o.code = metrics.CodeEncodeError
+}
+
+// done ends the call; a negative reqBytes means the request was never encoded.
+func (o *observation) done(resp *protocol.Response, err error, reqBytes int) {
+	code := o.code
+	if code == "" {
+		code = callCode(resp, err)
+	}
+	errMsg := ""
+	switch {
+	case err != nil:
+		errMsg = err.Error()
+	case resp != nil:
+		errMsg = resp.Error
+	}
+	o.end(code, reqBytes, responseSize(resp), errMsg)
+}
+
+// streamDone ends a stream that finished with err; message sizes are not recorded.
+func (o *observation) streamDone(err error) {
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	o.end(streamCode(err), -1, -1, errMsg)
+}
+
+func (o *observation) end(code string, reqBytes, respBytes int, errMsg string) {
+	o.c.metrics.Done(o.method, code, o.start, reqBytes, respBytes)
+	o.span.End(code, reqBytes, respBytes, errMsg)
+}
+
+// callCode classifies a finished call for the requests_total "code" label.
+// Rejections by the server arrive as response errors carrying the transport
+// error text.
+func callCode(resp *protocol.Response, err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return metrics.CodeCircuitOpen
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return metrics.CodeCanceled
+	case errors.Is(err, transport.ErrMessageTooLarge):
+		return metrics.CodeTooLarge
+	case err != nil:
+		return metrics.CodeUnavailable
+	case resp == nil || resp.Error == "":
+		// a one-way call has no response
+		return metrics.CodeOK
+	case resp.Error == transport.ErrTooManyRequests.Error():
+		return metrics.CodeTooManyRequests
+	case resp.Error == transport.ErrMessageTooLarge.Error():
+		return metrics.CodeTooLarge
+	}
+	return metrics.CodeError
+}
+
+// streamCode classifies how a stream ended: the server's status is classified
+// like a response error.
+func streamCode(err error) string {
+	var se *transport.StatusError
+	switch {
+	case err == nil:
+		return metrics.CodeOK
+	case errors.Is(err, transport.ErrStreamClosed):
+		return metrics.CodeCanceled
+	case errors.As(err, &se):
+		return callCode(&protocol.Response{Error: se.Message}, nil)
+	}
+	return callCode(nil, err)
+}
+
+// responseSize returns the encoded result size, or -1 if there is no response.
+func responseSize(resp *protocol.Response) int {
+	switch {
+	case resp == nil:
+		return -1
+	case resp.Data == nil:
+		return 0
+	}
+	return len(*resp.Data)
+}
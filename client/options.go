@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+	"xxrpc/tracing"
+)
+
+type Option interface {
+	Apply(*Client)
+}
+
+// optionFunc is a functional option for configuring the client.
+type optionFunc func(*Client)
+
+func (f optionFunc) Apply(c *Client) {
+	f(c)
+}
+
+func WithCodec(c codec.Codec) Option {
+	return optionFunc(func(cli *Client) {
+		cli.codec = c
+	})
+}
+
+// WithBreaker enables a circuit breaker for the client's target.
+// With cfg.PerMethod set, every service method gets its own breaker.
+func WithBreaker(cfg BreakerConfig) Option {
+	return optionFunc(func(cli *Client) {
+		cli.breakers = newBreakerGroup(cfg)
+	})
+}
+
+// WithMaxMessageSize limits the size of a response or stream message received
+// from the server. Larger messages fail the call with transport.ErrMessageTooLarge.
+func WithMaxMessageSize(n int) Option {
+	return optionFunc(func(cli *Client) {
+		cli.maxMessageSize = n
+	})
+}
+
+// WithKeepalive pings the server after interval without traffic. If no answer
+// arrives within timeout the connection is closed and reported unhealthy.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return optionFunc(func(cli *Client) {
+		cli.pingInterval = interval
+		cli.pingTimeout = timeout
+	})
+}
+
+// WithRegistry lets the server call the services registered in r over the
+// client's connection. Services must be registered with the client's codec
+// before Dial.
+func WithRegistry(r *registry.Registry) Option {
+	return optionFunc(func(cli *Client) {
+		cli.registry = r
+	})
+}
+
+// WithMetrics registers the client's Prometheus collectors (see package metrics)
+// with reg. Clients sharing reg share the collectors; Dial fails if they cannot
+// be registered.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return optionFunc(func(cli *Client) {
+		cli.metricsReg = reg
+	})
+}
+
+// WithDialer sets the function Dial uses to connect, e.g. to reach a Unix
+// domain socket, go through a proxy, wrap the connection or use a memconn
+// listener. The default dials TCP.
+func WithDialer(dial func(ctx context.Context, addr string) (net.Conn, error)) Option {
+	return optionFunc(func(cli *Client) {
+		cli.dialer = dial
+	})
+}
+
+// WithTracerProvider creates a client span for every call and sends its trace
+// context to the server in the request metadata as a W3C traceparent.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return optionFunc(func(cli *Client) {
+		cli.tracer = tracing.New(tp)
+	})
+}
+
+// CallOption configures a single call.
+type CallOption interface {
+	apply(*callOptions)
+}
+
+type callOptions struct {
+	ctx      context.Context
+	metadata map[string]string
+	hashKey  string
+}
+
+type callOptionFunc func(*callOptions)
+
+func (f callOptionFunc) apply(o *callOptions) {
+	f(o)
+}
+
+func newCallOptions(opts []CallOption) *callOptions {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+	return o
+}
+
+// done returns the Done channel of the call's context, nil without one.
+func (o *callOptions) done() <-chan struct{} {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Done()
+}
+
+// WithMetadata attaches metadata to the request. Repeated options are merged.
+func WithMetadata(md map[string]string) CallOption {
+	return callOptionFunc(func(o *callOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string, len(md))
+		}
+		for k, v := range md {
+			o.metadata[k] = v
+		}
+	})
+}
+
+// WithContext bounds Call, Go and streams by ctx: once it is done they fail
+// with ctx.Err() without waiting for the server, which still runs the call.
+// Batches and one-way calls only take the trace context: with
+// WithTracerProvider the call's span is a child of the span in ctx.
+func WithContext(ctx context.Context) CallOption {
+	return callOptionFunc(func(o *callOptions) {
+		o.ctx = ctx
+	})
+}
+
+// WithHashKey sets the routing key used by hash based balancers.
+func WithHashKey(key string) CallOption {
+	return callOptionFunc(func(o *callOptions) {
+		o.hashKey = key
+	})
+}
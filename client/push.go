@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+
+	"xxrpc/internal/transport"
+	"xxrpc/protocol"
+	"xxrpc/registry"
+)
+
+// registryHandler serves calls pushed by the server from the client's registry.
+type registryHandler struct {
+	r *registry.Registry
+}
+
+func (h registryHandler) ServeRequest(_ *transport.Conn, req *protocol.Request, resp *protocol.Response) {
+	h.r.Invoke(req, resp)
+}
+
+// ServeOneway drops failures: there is no one to report them to.
+func (h registryHandler) ServeOneway(_ *transport.Conn, req *protocol.Request) {
+	h.r.Invoke(req, &protocol.Response{})
+}
+
+func (h registryHandler) ServeStream(_ *transport.Conn, st *transport.Stream) error {
+	handler, err := h.r.FindStream(st.Method())
+	if err != nil {
+		return err
+	}
+	st.SetContext(registry.NewContext(context.Background(), st.Metadata()))
+	return handler(st)
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"time"
+
+	"xxrpc/balancer"
+	"xxrpc/discovery"
+)
+
+const resolveRetryInterval = time.Second
+
+// DialService resolves service through r and returns a BalancedClient that
+// follows the instance set as it changes. It blocks until the first resolution.
+func DialService(r discovery.Resolver, service string, opts ...BalancedOption) (*BalancedClient, error) {
+	w, err := r.Watch(service)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := w.Next()
+	if err != nil {
+		w.Stop()
+		return nil, err
+	}
+
+	bc := NewBalancedClient(toEndpoints(instances), opts...)
+	bc.watcher = w
+	go bc.watch(w)
+	return bc, nil
+}
+
+func (bc *BalancedClient) watch(w discovery.Watcher) {
+	for {
+		instances, err := w.Next()
+		if err != nil {
+			select {
+			case <-bc.done:
+				return
+			case <-time.After(resolveRetryInterval):
+				continue
+			}
+		}
+		bc.UpdateEndpoints(toEndpoints(instances))
+	}
+}
+
+func toEndpoints(instances []discovery.Instance) []balancer.Endpoint {
+	eps := make([]balancer.Endpoint, 0, len(instances))
+	for _, ins := range instances {
+		eps = append(eps, balancer.Endpoint{
+			Addr:     ins.Addr,
+			Weight:   ins.Weight,
+			Metadata: ins.Metadata,
+		})
+	}
+	return eps
+}
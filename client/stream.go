@@ -0,0 +1,94 @@
+package client
+
+import (
+	"xxrpc/internal/transport"
+)
+
+// Stream is a client-side bidirectional stream. Client-streaming calls send
+// several messages, CloseSend and receive one; server-streaming calls send one,
+// CloseSend and receive until io.EOF.
+type Stream struct {
+	s *transport.Stream
+}
+
+// NewStream opens a stream to a method registered with a StreamHandler.
+func (c *Client) NewStream(serviceMethod string, opts ...CallOption) (*Stream, error) {
+	co := newCallOptions(opts)
+	o, md := c.observe(co.ctx, serviceMethod, co.metadata)
+	var cb *Breaker
+	var gen uint64
+	if c.breakers != nil {
+		cb = c.breakers.get(c.addr, serviceMethod)
+		var err error
+		if gen, err = cb.Allow(); err != nil {
+			o.streamDone(err)
+			return nil, err
+		}
+	}
+
+	s, err := c.conn.NewStream(serviceMethod, md)
+	if cb != nil {
+		cb.Done(gen, c.breakers.cfg.IsFailure(nil, err))
+	}
+	if err != nil {
+		o.streamDone(err)
+		return nil, err
+	}
+	// the connection finishes every stream when it fails, so this always returns
+	go func() {
+		select {
+		case <-s.Done():
+		case <-co.done():
+			s.Abort(co.ctx.Err())
+		}
+		o.streamDone(s.Err())
+	}()
+	return &Stream{s: s}, nil
+}
+
+// SendMsg sends m, blocking while the server's receive window is full.
+func (s *Stream) SendMsg(m any) error {
+	return s.s.SendMsg(m)
+}
+
+// RecvMsg receives the next message into m. It returns io.EOF when the server
+// ended the stream successfully, or the server's error otherwise.
+func (s *Stream) RecvMsg(m any) error {
+	return s.s.RecvMsg(m)
+}
+
+// CloseSend tells the server no more messages will be sent.
+func (s *Stream) CloseSend() error {
+	return s.s.CloseSend()
+}
+
+// Close aborts the stream if the server has not ended it yet.
+func (s *Stream) Close() error {
+	return s.s.Close()
+}
+
+// BidiStream is a Stream with typed messages: Req is sent, Resp is received.
+type BidiStream[Req, Resp any] struct {
+	*Stream
+}
+
+// OpenStream opens a typed stream on c.
+func OpenStream[Req, Resp any](c *Client, serviceMethod string, opts ...CallOption) (*BidiStream[Req, Resp], error) {
+	s, err := c.NewStream(serviceMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &BidiStream[Req, Resp]{Stream: s}, nil
+}
+
+func (s *BidiStream[Req, Resp]) Send(req *Req) error {
+	return s.SendMsg(req)
+}
+
+func (s *BidiStream[Req, Resp]) Recv() (*Resp, error) {
+	resp := new(Resp)
+	if err := s.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
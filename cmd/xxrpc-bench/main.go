@@ -0,0 +1,344 @@
+// Command xxrpc-bench load-tests one method of an xxrpc server.
+//
+//	xxrpc-bench -method EchoService.SayHello -data '{"Message":"hi {{seq}}"}' -c 100 -conns 4 -d 30s
+//
+// The payload template may contain {{seq}} (the request number, from 1),
+// {{rand}} (a random non-negative integer) and {{now}} (Unix time in
+// nanoseconds). Calls are spread over -c workers sharing -conns connections,
+// optionally paced to -rps calls per second, until -d has passed or -n calls
+// have been made. With -rps, latency is measured from when each call was
+// scheduled rather than when it was sent, so a stalled server shows up in the
+// percentiles instead of silently lowering the rate. The report lists
+// throughput, latency percentiles, accurate to about 1%, and errors by message;
+// -json prints it as JSON for comparing runs.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/internal/cmdutil"
+	"xxrpc/internal/codec"
+)
+
+type config struct {
+	addr        string
+	codec       codec.Codec
+	method      string
+	payload     *template
+	metadata    map[string]string
+	concurrency int
+	conns       int
+	rps         float64
+	duration    time.Duration
+	requests    int64
+}
+
+func main() {
+	md := cmdutil.Metadata{}
+	var cfg config
+	flag.StringVar(&cfg.addr, "addr", "localhost:8888", "server address")
+	codecName := flag.String("codec", "jsoniter", "codec the server uses: json or jsoniter")
+	flag.StringVar(&cfg.method, "method", "", "method to call, as Service.Method")
+	data := flag.String("data", "{}", "JSON payload template")
+	flag.Var(md, "md", "request metadata as key=value, may be repeated")
+	flag.IntVar(&cfg.concurrency, "c", 50, "concurrent workers")
+	flag.IntVar(&cfg.conns, "conns", 1, "connections shared by the workers")
+	flag.Float64Var(&cfg.rps, "rps", 0, "target calls per second across all workers, 0 for as fast as possible")
+	flag.DurationVar(&cfg.duration, "d", 0, "how long to run (default 10s unless -n is set)")
+	flag.Int64Var(&cfg.requests, "n", 0, "stop after this many calls")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	var err error
+	if cfg.codec, err = cmdutil.Codec(*codecName); err != nil {
+		fatalf("%v", err)
+	}
+	if cfg.method == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.concurrency < 1 || cfg.conns < 1 {
+		fatalf("-c and -conns must be at least 1")
+	}
+	if cfg.duration == 0 && cfg.requests == 0 {
+		cfg.duration = 10 * time.Second
+	}
+	payload, err := parseTemplate(*data)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cfg.payload = payload
+	cfg.metadata = md
+
+	rep, err := run(&cfg)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		return
+	}
+	rep.print()
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "xxrpc-bench: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// template renders the payload of each call.
+type template struct {
+	raw    string
+	static bool // no placeholders, raw is sent as is
+}
+
+func parseTemplate(s string) (*template, error) {
+	t := &template{raw: s, static: !strings.Contains(s, "{{")}
+	if !json.Valid(t.render(0, rand.New(rand.NewSource(1)))) {
+		return nil, errors.New("payload template does not render valid JSON")
+	}
+	return t, nil
+}
+
+func (t *template) render(seq int64, rnd *rand.Rand) json.RawMessage {
+	if t.static {
+		return json.RawMessage(t.raw)
+	}
+	r := strings.NewReplacer(
+		"{{seq}}", strconv.FormatInt(seq, 10),
+		"{{rand}}", strconv.FormatInt(rnd.Int63(), 10),
+		"{{now}}", strconv.FormatInt(time.Now().UnixNano(), 10),
+	)
+	return json.RawMessage(r.Replace(t.raw))
+}
+
+// worker results, merged once the run is over.
+type result struct {
+	latencies histogram
+	errors    map[string]int
+}
+
+func run(cfg *config) (*report, error) {
+	clients := make([]*client.Client, cfg.conns)
+	for i := range clients {
+		cli, err := client.Dial(cfg.addr, client.WithCodec(cfg.codec))
+		if err != nil {
+			return nil, err
+		}
+		defer cli.Close()
+		clients[i] = cli
+	}
+
+	// stop is closed once the duration has passed or the last call was made
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	halt := func() { stopOnce.Do(func() { close(stop) }) }
+	if cfg.duration > 0 {
+		timer := time.AfterFunc(cfg.duration, halt)
+		defer timer.Stop()
+	}
+	var tokens <-chan time.Time
+	if cfg.rps > 0 {
+		tokens = pace(cfg.rps, cfg.concurrency, stop)
+	}
+
+	var seq atomic.Int64
+	results := make([]result, cfg.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < cfg.concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			cli := clients[w%len(clients)]
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			res := &results[w]
+			res.errors = make(map[string]int)
+			opts := []client.CallOption{client.WithMetadata(cfg.metadata)}
+			for {
+				// with -rps latency counts from when the call was due, so
+				// time spent queued behind slow calls is not hidden
+				var due time.Time
+				if tokens != nil {
+					select {
+					case due = <-tokens:
+					case <-stop:
+						return
+					}
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n := seq.Add(1)
+				if cfg.requests > 0 && n > cfg.requests {
+					halt()
+					return
+				}
+
+				payload := cfg.payload.render(n, rnd)
+				if due.IsZero() {
+					due = time.Now()
+				}
+				resp, err := cli.Call(cfg.method, payload, opts...)
+				res.latencies.record(time.Since(due))
+				switch {
+				case err != nil:
+					res.errors[err.Error()]++
+				case resp.Error != "":
+					res.errors[resp.Error]++
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return newReport(cfg, elapsed, results), nil
+}
+
+// pace returns a channel yielding rps tokens per second until stop is closed.
+// Each token is the time its call was scheduled for. At most burst tokens are
+// buffered while the workers fall behind.
+func pace(rps float64, burst int, stop <-chan struct{}) <-chan time.Time {
+	tokens := make(chan time.Time, burst)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		start := time.Now()
+		var sent int64
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				due := int64(now.Sub(start).Seconds() * rps)
+				for ; sent < due; sent++ {
+					at := start.Add(time.Duration(float64(sent) / rps * float64(time.Second)))
+					select {
+					case tokens <- at:
+					case <-stop:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return tokens
+}
+
+type latencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+type report struct {
+	Method      string         `json:"method"`
+	Concurrency int            `json:"concurrency"`
+	Connections int            `json:"connections"`
+	TargetRPS   float64        `json:"target_rps,omitempty"`
+	Duration    float64        `json:"duration_seconds"`
+	Requests    int            `json:"requests"`
+	Succeeded   int            `json:"succeeded"`
+	Failed      int            `json:"failed"`
+	Throughput  float64        `json:"throughput_rps"`
+	Latency     latencyReport  `json:"latency_ms"` // of every call, failed ones included
+	Errors      map[string]int `json:"errors,omitempty"`
+}
+
+func newReport(cfg *config, elapsed time.Duration, results []result) *report {
+	rep := &report{
+		Method:      cfg.method,
+		Concurrency: cfg.concurrency,
+		Connections: cfg.conns,
+		TargetRPS:   cfg.rps,
+		Duration:    elapsed.Seconds(),
+		Errors:      make(map[string]int),
+	}
+	var all histogram
+	for i := range results {
+		all.merge(&results[i].latencies)
+		for msg, n := range results[i].errors {
+			rep.Errors[msg] += n
+			rep.Failed += n
+		}
+	}
+	rep.Requests = int(all.total)
+	rep.Succeeded = rep.Requests - rep.Failed
+	if elapsed > 0 {
+		rep.Throughput = float64(rep.Requests) / elapsed.Seconds()
+	}
+	if all.total == 0 {
+		return rep
+	}
+
+	rep.Latency = latencyReport{
+		Min:  ms(all.min),
+		Mean: ms(all.mean()),
+		P50:  ms(all.quantile(0.50)),
+		P90:  ms(all.quantile(0.90)),
+		P99:  ms(all.quantile(0.99)),
+		P999: ms(all.quantile(0.999)),
+		Max:  ms(all.max),
+	}
+	return rep
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *report) print() {
+	fmt.Printf("Method:       %s\n", r.Method)
+	fmt.Printf("Concurrency:  %d workers on %d connection(s)\n", r.Concurrency, r.Connections)
+	if r.TargetRPS > 0 {
+		fmt.Printf("Target rate:  %.0f/s\n", r.TargetRPS)
+	}
+	fmt.Printf("Duration:     %.2fs\n", r.Duration)
+	fmt.Printf("Requests:     %d (%.1f/s)\n", r.Requests, r.Throughput)
+	fmt.Printf("Succeeded:    %d\n", r.Succeeded)
+	fmt.Printf("Failed:       %d\n", r.Failed)
+	fmt.Println()
+	fmt.Println("Latency (ms):")
+	l := r.Latency
+	for _, row := range []struct {
+		name string
+		v    float64
+	}{{"min", l.Min}, {"mean", l.Mean}, {"p50", l.P50}, {"p90", l.P90}, {"p99", l.P99}, {"p999", l.P999}, {"max", l.Max}} {
+		fmt.Printf("  %-5s %10.3f\n", row.name, row.v)
+	}
+
+	if len(r.Errors) == 0 {
+		return
+	}
+	msgs := make([]string, 0, len(r.Errors))
+	for msg := range r.Errors {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return r.Errors[msgs[i]] > r.Errors[msgs[j]] })
+	fmt.Println()
+	fmt.Println("Errors:")
+	for _, msg := range msgs {
+		fmt.Printf("  %8d  %s\n", r.Errors[msg], msg)
+	}
+}
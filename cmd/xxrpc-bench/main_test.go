@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// nearestRank is the exact quantile the histogram approximates.
+func nearestRank(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.999999) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name string
+		gen  func(i int) time.Duration
+		n    int
+	}{
+		{"single", func(int) time.Duration { return 3 * time.Millisecond }, 1},
+		{"exact nanoseconds", func(i int) time.Duration { return time.Duration(i % 100) }, 1000},
+		{"linear", func(i int) time.Duration { return time.Duration(i+1) * time.Millisecond }, 1000},
+		{"exponential", func(int) time.Duration {
+			return time.Duration(rnd.ExpFloat64() * float64(5*time.Millisecond))
+		}, 100000},
+		{"outliers", func(i int) time.Duration {
+			if i%1000 == 0 {
+				return 2 * time.Second
+			}
+			return 200 * time.Microsecond
+		}, 10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// spread the values over two workers to exercise merge
+			var a, b, all histogram
+			values := make([]time.Duration, tt.n)
+			for i := range values {
+				values[i] = tt.gen(i)
+				if i%2 == 0 {
+					a.record(values[i])
+				} else {
+					b.record(values[i])
+				}
+			}
+			all.merge(&a)
+			all.merge(&b)
+			sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+			if all.total != int64(tt.n) || all.min != values[0] || all.max != values[tt.n-1] {
+				t.Fatalf("total, min, max = %d, %v, %v, want %d, %v, %v",
+					all.total, all.min, all.max, tt.n, values[0], values[tt.n-1])
+			}
+			for _, p := range []float64{0, 0.5, 0.9, 0.99, 0.999, 1} {
+				got, want := all.quantile(p), nearestRank(values, p)
+				if diff := got - want; diff > want/100 || -diff > want/100 {
+					t.Errorf("p%v = %v, want %v within 1%%", p*100, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	prev := -1
+	for _, d := range []time.Duration{0, 1, 127, 128, 129, 255, 256, time.Millisecond, time.Second, time.Hour, 1<<63 - 1} {
+		i := bucketOf(d)
+		if i < prev || i >= histBuckets {
+			t.Fatalf("bucketOf(%v) = %d after %d", d, i, prev)
+		}
+		prev = i
+		if mid := bucketMid(i); mid-d > d/64 || d-mid > d/64 {
+			t.Errorf("bucketMid(bucketOf(%v)) = %v", d, mid)
+		}
+	}
+	var empty histogram
+	if empty.quantile(0.5) != 0 || empty.mean() != 0 {
+		t.Fatal("empty histogram is not zero")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		raw     string
+		check   func(v map[string]any) bool
+		invalid bool
+	}{
+		{raw: `{"Message":"hi"}`, check: func(v map[string]any) bool { return v["Message"] == "hi" }},
+		{raw: `{"Seq":{{seq}}}`, check: func(v map[string]any) bool { return v["Seq"] == 42.0 }},
+		{raw: `{"Message":"hi {{seq}}"}`, check: func(v map[string]any) bool { return v["Message"] == "hi 42" }},
+		{raw: `{"Rand":{{rand}}}`, check: func(v map[string]any) bool { return v["Rand"].(float64) >= 0 }},
+		{raw: `{"Now":"{{now}}"}`, check: func(v map[string]any) bool {
+			ns, err := strconv.ParseInt(v["Now"].(string), 10, 64)
+			return err == nil && time.Since(time.Unix(0, ns)) < time.Minute
+		}},
+		{raw: `{"Message":}`, invalid: true},
+		{raw: `{{seq}`, invalid: true},
+	}
+	for _, tt := range tests {
+		tpl, err := parseTemplate(tt.raw)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("parseTemplate(%s) accepted invalid JSON", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTemplate(%s) = %v", tt.raw, err)
+			continue
+		}
+		var v map[string]any
+		out := tpl.render(42, rand.New(rand.NewSource(1)))
+		if err := json.Unmarshal(out, &v); err != nil || !tt.check(v) {
+			t.Errorf("render(%s) = %s, %v", tt.raw, out, err)
+		}
+	}
+}
+
+func TestPace(t *testing.T) {
+	for _, rps := range []float64{200, 2000} {
+		stop := make(chan struct{})
+		tokens := pace(rps, 4, stop)
+		const n = 40
+		var first time.Time
+		for i := 0; i < n; i++ {
+			at := <-tokens
+			if now := time.Now(); at.After(now) {
+				t.Fatalf("rps %v: token %d for %v arrived early at %v", rps, i, at, now)
+			}
+			if i == 0 {
+				first = at
+				continue
+			}
+			// tokens carry their schedule, however late they are picked up
+			want := time.Duration(float64(i) / rps * float64(time.Second))
+			if got := at.Sub(first); got < want-time.Microsecond || got > want+time.Microsecond {
+				t.Fatalf("rps %v: token %d scheduled %v after the first, want %v", rps, i, got, want)
+			}
+		}
+		close(stop)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+
+	"go.uber.org/zap"
+
+	"xxrpc/naming"
+	"xxrpc/registry"
+	"xxrpc/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":7777", "listen address")
+	data := flag.String("data", "", "snapshot file for persisting leases, empty keeps them in memory only")
+	flag.Parse()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	opts := []naming.Option{naming.WithLogger(logger.Named("naming"))}
+	if *data != "" {
+		opts = append(opts, naming.WithPersistence(*data))
+	}
+	svc, err := naming.NewService(opts...)
+	if err != nil {
+		logger.Fatal("failed to load naming data", zap.Error(err))
+	}
+
+	s := server.NewServer(*addr, registry.NewRegister(), server.WithLogger(logger.Named("naming")))
+	s.Register(svc)
+	if err := s.Start(); err != nil {
+		logger.Fatal("naming server stopped", zap.Error(err))
+	}
+}
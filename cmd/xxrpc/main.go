@@ -0,0 +1,209 @@
+// Command xxrpc makes ad-hoc calls to an xxrpc server.
+//
+//	xxrpc [flags] list                              list methods (needs server.WithReflection)
+//	xxrpc [flags] describe Service.Method           print a method's request and response schemas
+//	xxrpc [flags] call Service.Method [args|-]      call a method with JSON args, - reads them from stdin
+//
+// With -stream, call opens a stream, sends args as its only message and prints
+// every message received until the server ends the stream.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/internal/cmdutil"
+	"xxrpc/reflection"
+)
+
+func main() {
+	md := cmdutil.Metadata{}
+	addr := flag.String("addr", "localhost:8888", "server address")
+	codecName := flag.String("codec", "jsoniter", "codec the server uses: json or jsoniter")
+	timeout := flag.Duration("timeout", 10*time.Second, "deadline for connecting and the call, 0 waits forever")
+	stream := flag.Bool("stream", false, "call a stream method")
+	flag.Var(md, "md", "request metadata as key=value, may be repeated")
+	flag.Usage = usage
+	flag.Parse()
+
+	c, err := cmdutil.Codec(*codecName)
+	if err != nil {
+		fatalf(2, "%v", err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	var run func(*client.Client, []client.CallOption) error
+	switch cmd := args[0]; {
+	case cmd == "list" && len(args) == 1:
+		run = list
+	case cmd == "describe" && len(args) == 2:
+		run = func(cli *client.Client, opts []client.CallOption) error {
+			return describe(cli, args[1], opts)
+		}
+	case cmd == "call" && (len(args) == 2 || len(args) == 3):
+		payload, err := readArgs(args[2:])
+		if err != nil {
+			fatalf(2, "%v", err)
+		}
+		run = func(cli *client.Client, opts []client.CallOption) error {
+			if *stream {
+				return callStream(cli, args[1], payload, opts)
+			}
+			return call(cli, args[1], payload, opts)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	dial := func(_ context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	cli, err := client.Dial(*addr, client.WithCodec(c), client.WithDialer(dial))
+	if err == nil {
+		defer cli.Close()
+		err = run(cli, []client.CallOption{client.WithMetadata(md), client.WithContext(ctx)})
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			fatalf(1, "timed out after %v", *timeout)
+		}
+		fatalf(1, "%v", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: xxrpc [flags] list
+       xxrpc [flags] describe Service.Method
+       xxrpc [flags] call Service.Method ['{"json":"args"}' | -]
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func fatalf(code int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "xxrpc: "+format+"\n", args...)
+	os.Exit(code)
+}
+
+// readArgs returns the call's JSON arguments: the argument itself, stdin for
+// "-", or {} when there is none.
+func readArgs(args []string) (json.RawMessage, error) {
+	if len(args) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	data := []byte(args[0])
+	if args[0] == "-" {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	if !json.Valid(data) {
+		return nil, errors.New("arguments are not valid JSON")
+	}
+	return json.RawMessage(data), nil
+}
+
+// unary calls method and decodes its result into out.
+func unary(cli *client.Client, method string, args, out any, opts []client.CallOption) error {
+	resp, err := cli.Call(method, args, opts...)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if resp.Data == nil {
+		return nil
+	}
+	return json.Unmarshal(*resp.Data, out)
+}
+
+func list(cli *client.Client, opts []client.CallOption) error {
+	var resp reflection.ListResp
+	if err := unary(cli, reflection.ServiceName+".List", &reflection.ListReq{}, &resp, opts); err != nil {
+		return fmt.Errorf("%w (is the server started with server.WithReflection?)", err)
+	}
+	for _, svc := range resp.Services {
+		fmt.Println(svc.Name)
+		for _, m := range svc.Methods {
+			fmt.Printf("  %-40s %s\n", m.Name, m.Kind)
+		}
+	}
+	return nil
+}
+
+func describe(cli *client.Client, method string, opts []client.CallOption) error {
+	var info json.RawMessage
+	if err := unary(cli, reflection.ServiceName+".Describe", &reflection.DescribeReq{Method: method}, &info, opts); err != nil {
+		return err
+	}
+	printJSON(info)
+	return nil
+}
+
+func call(cli *client.Client, method string, args json.RawMessage, opts []client.CallOption) error {
+	var out json.RawMessage
+	if err := unary(cli, method, args, &out, opts); err != nil {
+		return err
+	}
+	printJSON(out)
+	return nil
+}
+
+func callStream(cli *client.Client, method string, args json.RawMessage, opts []client.CallOption) error {
+	s, err := cli.NewStream(method, opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.SendMsg(args); err != nil {
+		return err
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var msg json.RawMessage
+		if err := s.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		printJSON(msg)
+	}
+}
+
+// printJSON pretty-prints data, or prints it as is if it is not JSON.
+func printJSON(data []byte) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		os.Stdout.Write(data)
+		fmt.Println()
+		return
+	}
+	fmt.Println(buf.String())
+}
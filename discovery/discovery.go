@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrServiceNotFound = errors.New("discovery: service not found")
+	ErrWatcherStopped  = errors.New("discovery: watcher stopped")
+)
+
+// Instance is one running endpoint of a service.
+type Instance struct {
+	Service  string
+	Addr     string
+	Weight   int               `json:",omitempty"`
+	Tags     []string          `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+}
+
+// Resolver finds where a service lives.
+type Resolver interface {
+	// Resolve returns the current instances of service.
+	Resolve(service string) ([]Instance, error)
+	// Watch follows the instances of service until the watcher is stopped.
+	Watch(service string) (Watcher, error)
+}
+
+// Watcher yields the instance set of a service every time it changes.
+type Watcher interface {
+	// Next blocks until there is a new instance set. The first call returns the current set.
+	Next() ([]Instance, error)
+	Stop() error
+}
+
+func sortInstances(instances []Instance) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+}
+
+func sameInstances(a, b []Instance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr || a[i].Weight != b[i].Weight ||
+			!sameTags(a[i].Tags, b[i].Tags) || !sameMetadata(a[i].Metadata, b[i].Metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticWatch(t *testing.T) {
+	s := NewStaticAddrs("echo", "127.0.0.1:8001")
+	w, err := s.Watch("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	instances, err := w.Next()
+	if err != nil || len(instances) != 1 || instances[0].Addr != "127.0.0.1:8001" {
+		t.Fatalf("Next() = %v, %v", instances, err)
+	}
+
+	next := make(chan []Instance, 1)
+	go func() {
+		instances, _ := w.Next()
+		next <- instances
+	}()
+
+	s.Set("echo", []Instance{{Addr: "127.0.0.1:8002"}, {Addr: "127.0.0.1:8001"}})
+	select {
+	case instances := <-next:
+		if len(instances) != 2 || instances[0].Addr != "127.0.0.1:8001" || instances[0].Service != "echo" {
+			t.Fatalf("Next() after Set = %v", instances)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher was not notified")
+	}
+}
+
+func TestDNSResolve(t *testing.T) {
+	d := NewDNS("8888", time.Second)
+	d.lookup = func(_ context.Context, host string) ([]string, error) {
+		if host != "echo.internal" {
+			t.Fatalf("lookup host = %q", host)
+		}
+		return []string{"10.0.0.2", "10.0.0.1"}, nil
+	}
+
+	instances, err := d.Resolve("echo.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].Addr != "10.0.0.1:8888" || instances[1].Addr != "10.0.0.2:8888" {
+		t.Fatalf("Resolve() = %v", instances)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("services:\n  echo:\n    - addr: 127.0.0.1:8001\n      weight: 3\n      tags: [canary]\n")
+	f, err := NewFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, _ := f.Watch("echo")
+	defer w.Stop()
+	instances, err := w.Next()
+	if err != nil || len(instances) != 1 || instances[0].Weight != 3 || instances[0].Tags[0] != "canary" {
+		t.Fatalf("Next() = %v, %v", instances, err)
+	}
+
+	write("services:\n  echo:\n    - addr: 127.0.0.1:8001\n    - addr: 127.0.0.1:8002\n")
+	next := make(chan []Instance, 1)
+	go func() {
+		instances, _ := w.Next()
+		next <- instances
+	}()
+	select {
+	case instances := <-next:
+		if len(instances) != 2 {
+			t.Fatalf("Next() after reload = %v", instances)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher was not notified of the file change")
+	}
+
+	write("services: [")
+	for deadline := time.Now().Add(2 * time.Second); f.LastError() == nil; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a parse error for the broken file")
+		}
+	}
+	if instances, err := f.Resolve("echo"); err != nil || len(instances) != 2 {
+		t.Fatalf("Resolve() after broken reload = %v, %v", instances, err)
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	m := NewMemory()
+	var timers []func()
+	m.afterFunc = func(_ time.Duration, f func()) func() bool {
+		timers = append(timers, f)
+		return func() bool { return true }
+	}
+	ins := Instance{Service: "echo", Addr: "127.0.0.1:8001"}
+	if err := m.Register(ins, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// renewing keeps the instance alive past the first TTL, even if its timer
+	// fires anyway
+	m.Register(ins, 50*time.Millisecond)
+	if len(timers) != 2 {
+		t.Fatalf("%d timers started, want 2", len(timers))
+	}
+	timers[0]()
+	if _, err := m.Resolve("echo"); err != nil {
+		t.Fatalf("instance expired despite renewal: %v", err)
+	}
+
+	timers[1]()
+	if _, err := m.Resolve("echo"); err != ErrServiceNotFound {
+		t.Fatalf("Resolve() after TTL = %v, want ErrServiceNotFound", err)
+	}
+
+	m.Register(ins, 0)
+	if len(timers) != 2 {
+		t.Fatal("registering without a TTL started a timer")
+	}
+	m.Deregister(ins)
+	if _, err := m.Resolve("echo"); err != ErrServiceNotFound {
+		t.Fatalf("Resolve() after Deregister = %v, want ErrServiceNotFound", err)
+	}
+}
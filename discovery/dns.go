@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultDNSInterval = 30 * time.Second
+
+// DNS resolves a service name of the form "host:port" (or "host" with the
+// default port) into one instance per A/AAAA record, re-resolving every interval.
+type DNS struct {
+	port     string
+	interval time.Duration
+	lookup   func(ctx context.Context, host string) ([]string, error)
+}
+
+func NewDNS(defaultPort string, interval time.Duration) *DNS {
+	if interval <= 0 {
+		interval = defaultDNSInterval
+	}
+	return &DNS{
+		port:     defaultPort,
+		interval: interval,
+		lookup:   net.DefaultResolver.LookupHost,
+	}
+}
+
+func (d *DNS) split(service string) (string, string) {
+	host, port, err := net.SplitHostPort(service)
+	if err != nil {
+		return service, d.port
+	}
+	return host, port
+}
+
+func (d *DNS) Resolve(service string) ([]Instance, error) {
+	host, port := d.split(service)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+	defer cancel()
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, ErrServiceNotFound
+	}
+
+	instances := make([]Instance, 0, len(addrs))
+	for _, a := range addrs {
+		instances = append(instances, Instance{Service: service, Addr: net.JoinHostPort(a, port)})
+	}
+	sortInstances(instances)
+	return instances, nil
+}
+
+func (d *DNS) Watch(service string) (Watcher, error) {
+	return &dnsWatcher{dns: d, service: service, stop: make(chan struct{})}, nil
+}
+
+type dnsWatcher struct {
+	dns     *DNS
+	service string
+	last    []Instance
+	started bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Next polls until the resolved set differs from the previous one. Lookup
+// errors after the first successful resolution keep the last known set.
+func (w *dnsWatcher) Next() ([]Instance, error) {
+	if !w.started {
+		w.started = true
+		instances, err := w.dns.Resolve(w.service)
+		if err != nil {
+			return nil, err
+		}
+		w.last = instances
+		return instances, nil
+	}
+
+	ticker := time.NewTicker(w.dns.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return nil, ErrWatcherStopped
+		case <-ticker.C:
+		}
+		instances, err := w.dns.Resolve(w.service)
+		if err != nil || sameInstances(instances, w.last) {
+			continue
+		}
+		w.last = instances
+		return instances, nil
+	}
+}
+
+func (w *dnsWatcher) Stop() error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	return nil
+}
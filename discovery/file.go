@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultFileInterval = time.Second
+
+// fileConfig is the on-disk format, for example in YAML:
+//
+//	services:
+//	  EchoService:
+//	    - addr: 10.0.0.1:8888
+//	      weight: 2
+//	      tags: [canary]
+//	      metadata: {zone: a}
+//
+// Files ending in .json are decoded as JSON with the same field names.
+type fileConfig struct {
+	Services map[string][]fileEntry `json:"services" yaml:"services"`
+}
+
+type fileEntry struct {
+	Addr     string            `json:"addr" yaml:"addr"`
+	Weight   int               `json:"weight" yaml:"weight"`
+	Tags     []string          `json:"tags" yaml:"tags"`
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+// File is a resolver backed by a JSON or YAML file. The file is re-read every
+// interval and watchers are notified of the services whose instances changed.
+// A file that fails to parse is ignored and the last good contents stay in effect.
+type File struct {
+	*hub
+
+	path     string
+	interval time.Duration
+	last     []byte
+	known    map[string]struct{}
+
+	mu      sync.Mutex
+	lastErr error
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewFile(path string, interval time.Duration) (*File, error) {
+	if interval <= 0 {
+		interval = defaultFileInterval
+	}
+	f := &File{
+		hub:      newHub(),
+		path:     path,
+		interval: interval,
+		known:    make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	go f.poll()
+	return f, nil
+}
+
+// LastError returns the error of the most recent reload, if it failed.
+func (f *File) LastError() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
+
+func (f *File) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+	return nil
+}
+
+func (f *File) poll() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			err := f.reload()
+			f.mu.Lock()
+			f.lastErr = err
+			f.mu.Unlock()
+		}
+	}
+}
+
+func (f *File) reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if f.last != nil && bytes.Equal(data, f.last) {
+		return nil
+	}
+
+	cfg, err := parseFileConfig(f.path, data)
+	if err != nil {
+		return err
+	}
+	f.last = data
+
+	for name, entries := range cfg.Services {
+		instances := make([]Instance, 0, len(entries))
+		for _, e := range entries {
+			instances = append(instances, Instance{
+				Addr:     e.Addr,
+				Weight:   e.Weight,
+				Tags:     e.Tags,
+				Metadata: e.Metadata,
+			})
+		}
+		f.hub.set(name, instances)
+		f.known[name] = struct{}{}
+	}
+	for name := range f.known {
+		if _, ok := cfg.Services[name]; !ok {
+			f.hub.set(name, nil)
+			delete(f.known, name)
+		}
+	}
+	return nil
+}
+
+func parseFileConfig(path string, data []byte) (*fileConfig, error) {
+	var cfg fileConfig
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import "sync"
+
+// hub keeps the instance sets of all services and wakes watchers on change.
+// The static, file and in-memory backends are built on it.
+type hub struct {
+	mu       sync.Mutex
+	services map[string][]Instance
+	changed  map[string]chan struct{} // closed and replaced on every change
+}
+
+func newHub() *hub {
+	return &hub{
+		services: make(map[string][]Instance),
+		changed:  make(map[string]chan struct{}),
+	}
+}
+
+func (h *hub) get(service string) ([]Instance, chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.services[service], h.signal(service)
+}
+
+func (h *hub) signal(service string) chan struct{} {
+	ch, ok := h.changed[service]
+	if !ok {
+		ch = make(chan struct{})
+		h.changed[service] = ch
+	}
+	return ch
+}
+
+// set replaces the instances of service and reports whether anything changed.
+func (h *hub) set(service string, instances []Instance) bool {
+	next := make([]Instance, len(instances))
+	copy(next, instances)
+	for i := range next {
+		next[i].Service = service
+	}
+	sortInstances(next)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sameInstances(h.services[service], next) {
+		return false
+	}
+	if len(next) == 0 {
+		delete(h.services, service)
+	} else {
+		h.services[service] = next
+	}
+	close(h.signal(service))
+	delete(h.changed, service)
+	return true
+}
+
+func (h *hub) Resolve(service string) ([]Instance, error) {
+	instances, _ := h.get(service)
+	if len(instances) == 0 {
+		return nil, ErrServiceNotFound
+	}
+	out := make([]Instance, len(instances))
+	copy(out, instances)
+	return out, nil
+}
+
+func (h *hub) Watch(service string) (Watcher, error) {
+	return &hubWatcher{hub: h, service: service, stop: make(chan struct{})}, nil
+}
+
+type hubWatcher struct {
+	hub     *hub
+	service string
+	started bool
+	wait    chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (w *hubWatcher) Next() ([]Instance, error) {
+	if w.started {
+		select {
+		case <-w.wait:
+		case <-w.stop:
+			return nil, ErrWatcherStopped
+		}
+	}
+	w.started = true
+
+	instances, wait := w.hub.get(w.service)
+	w.wait = wait
+	out := make([]Instance, len(instances))
+	copy(out, instances)
+	return out, nil
+}
+
+func (w *hubWatcher) Stop() error {
+	w.stopOnce.Do(func() { close(w.stop) })
+	return nil
+}
@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Resolver and Registrar. Instances registered with a
+// TTL expire unless they are registered again before it runs out.
+type Memory struct {
+	*hub
+
+	mu     sync.Mutex
+	leases map[string]map[string]*memoryLease // service -> addr -> lease
+
+	afterFunc func(d time.Duration, f func()) (stop func() bool) // replaced in tests
+}
+
+type memoryLease struct {
+	instance Instance
+	stop     func() bool // stops the TTL timer, nil without a TTL
+	gen      uint64      // bumped on every renewal, stale timers compare against it
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		hub:    newHub(),
+		leases: make(map[string]map[string]*memoryLease),
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		},
+	}
+}
+
+func (m *Memory) Register(instance Instance, ttl time.Duration) error {
+	if instance.Service == "" || instance.Addr == "" {
+		return errors.New("discovery: instance needs a service and an address")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	leases, ok := m.leases[instance.Service]
+	if !ok {
+		leases = make(map[string]*memoryLease)
+		m.leases[instance.Service] = leases
+	}
+	l, ok := leases[instance.Addr]
+	if !ok {
+		l = &memoryLease{}
+		leases[instance.Addr] = l
+	}
+	l.instance = instance
+	l.gen++
+	if l.stop != nil {
+		l.stop()
+		l.stop = nil
+	}
+	if ttl > 0 {
+		gen := l.gen
+		l.stop = m.afterFunc(ttl, func() { m.expire(instance.Service, instance.Addr, l, gen) })
+	}
+	m.publish(instance.Service)
+	return nil
+}
+
+func (m *Memory) Deregister(instance Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.leases[instance.Service][instance.Addr]
+	if !ok {
+		return nil
+	}
+	if l.stop != nil {
+		l.stop()
+	}
+	delete(m.leases[instance.Service], instance.Addr)
+	m.publish(instance.Service)
+	return nil
+}
+
+func (m *Memory) expire(service, addr string, l *memoryLease, gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the lease may have been renewed or replaced since the timer fired
+	if cur, ok := m.leases[service][addr]; !ok || cur != l || l.gen != gen {
+		return
+	}
+	delete(m.leases[service], addr)
+	m.publish(service)
+}
+
+// publish pushes the lease table of service to watchers. m.mu must be held.
+func (m *Memory) publish(service string) {
+	leases := m.leases[service]
+	instances := make([]Instance, 0, len(leases))
+	for _, l := range leases {
+		instances = append(instances, l.instance)
+	}
+	if len(leases) == 0 {
+		delete(m.leases, service)
+	}
+	m.hub.set(service, instances)
+}
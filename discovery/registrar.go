@@ -0,0 +1,18 @@
+package discovery
+
+import "time"
+
+// Well-known Instance.Metadata keys.
+const (
+	MetadataVersion = "version"
+	MetadataZone    = "zone"
+)
+
+// Registrar advertises instances to a discovery backend.
+type Registrar interface {
+	// Register publishes instance for ttl. Registering the same service and
+	// address again refreshes the TTL, so it is also used for heartbeats.
+	// A ttl <= 0 keeps the instance until it is deregistered.
+	Register(instance Instance, ttl time.Duration) error
+	Deregister(instance Instance) error
+}
@@ -0,0 +1,29 @@
+package discovery
+
+// Static is a resolver backed by a fixed, in-process list of instances.
+// Set can be used to change the list at runtime, e.g. from tests.
+type Static struct {
+	*hub
+}
+
+func NewStatic(services map[string][]Instance) *Static {
+	s := &Static{hub: newHub()}
+	for name, instances := range services {
+		s.hub.set(name, instances)
+	}
+	return s
+}
+
+// NewStaticAddrs is a shorthand for a single service with equally weighted addresses.
+func NewStaticAddrs(service string, addrs ...string) *Static {
+	instances := make([]Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		instances = append(instances, Instance{Addr: addr})
+	}
+	return NewStatic(map[string][]Instance{service: instances})
+}
+
+// Set replaces the instances of service and notifies watchers.
+func (s *Static) Set(service string, instances []Instance) {
+	s.hub.set(service, instances)
+}
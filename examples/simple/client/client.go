@@ -12,76 +12,13 @@ import (
 
 // 配置参数
 const (
-	maxGoroutines      = 100           // 最大goroutine数量限制
-	runtimeMinSec      = 300           // 最小运行时间(秒)
-	connectionPoolSize = maxGoroutines // 连接池大小，根据服务器承载能力调整
-	statsInterval      = 5             // 统计信息打印间隔(秒)
+	maxInFlight   = 100 // 同时在途的最大调用数
+	runtimeMinSec = 300 // 最小运行时间(秒)
+	statsInterval = 5   // 统计信息打印间隔(秒)
 )
 
-// 连接池结构体，管理可复用的RPC连接
-type ConnectionPool struct {
-	pool chan *client.Client
-}
-
-// 创建新的连接池
-func NewConnectionPool(size int) (*ConnectionPool, error) {
-	pool := make(chan *client.Client, size)
-
-	// 初始化连接池
-	for i := 0; i < size; i++ {
-		cli, err := client.Dial(":8888")
-		if err != nil {
-			// 关闭已创建的连接
-			closeConnections(pool)
-			return nil, fmt.Errorf("创建连接失败: %v", err)
-		}
-		pool <- cli
-	}
-
-	return &ConnectionPool{
-		pool: pool,
-	}, nil
-}
-
-// 从连接池获取连接
-func (p *ConnectionPool) Get() *client.Client {
-	return <-p.pool
-}
-
-// 将连接放回连接池
-func (p *ConnectionPool) Put(cli *client.Client) {
-	select {
-	case p.pool <- cli:
-		// 成功放回连接池
-	default:
-		// 连接池已满，关闭多余连接
-		cli.Close()
-	}
-}
-
-// 关闭所有连接
-func (p *ConnectionPool) Close() {
-	close(p.pool)
-	for cli := range p.pool {
-		cli.Close()
-	}
-}
-
-// 关闭通道中的所有连接
-func closeConnections(pool chan *client.Client) {
-	close(pool)
-	for cli := range pool {
-		cli.Close()
-	}
-}
-
-// 使用复用的连接执行RPC调用
-func RPCCall(pool *ConnectionPool) error {
-	// 从连接池获取连接
-	cli := pool.Get()
-	defer pool.Put(cli) // 调用完成后放回连接池
-
-	req := echo.ComplexHelloReq{
+func newComplexHelloReq() echo.ComplexHelloReq {
+	return echo.ComplexHelloReq{
 		Message:   "Hello",
 		ID:        12345,
 		Timestamp: time.Now(),
@@ -105,22 +42,18 @@ func RPCCall(pool *ConnectionPool) error {
 			Timeout: time.Second,
 		},
 	}
-
-	// 执行RPC调用
-	_, err := cli.Call("EchoService.ComplexHello", req)
-	return err
 }
 
 func main() {
-	// 初始化连接池
-	pool, err := NewConnectionPool(connectionPoolSize)
+	// 所有调用复用同一个连接，通过 Go 异步发起
+	cli, err := client.Dial(":8888")
 	if err != nil {
-		log.Fatalf("初始化连接池失败: %v", err)
+		log.Fatalf("创建连接失败: %v", err)
 	}
-	defer pool.Close()
+	defer cli.Close()
 
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, maxGoroutines) // 控制并发数量的信号量
+	done := make(chan *client.Call, maxInFlight) // 所有调用共用的完成通道
+	inFlight := 0
 	startTime := time.Now()
 	taskCount := 0
 	successCount := 0
@@ -151,39 +84,38 @@ func main() {
 		}
 	}()
 
-	// 持续运行直到达到最小运行时间
-	for time.Duration(time.Since(startTime).Seconds()) < time.Duration(runtimeMinSec)*time.Second {
-		// 控制任务生成速度，避免过早填满信号量
-		semaphore <- struct{}{}
-		wg.Add(1)
-		taskCount++
-
-		go func() {
-			defer wg.Done()
-			defer func() { <-semaphore }()
-
-			// 执行RPC调用
-			err := RPCCall(pool)
+	// 收集一个已完成调用的结果
+	collect := func() {
+		call := <-done
+		inFlight--
+
+		mu.Lock()
+		if call.Error != nil {
+			errorCount++
+			log.Printf("rpc call error: %v", call.Error)
+		} else {
+			successCount++
+		}
+		mu.Unlock()
+	}
 
-			// 更新统计数据
+	// 持续运行直到达到最小运行时间
+	for time.Since(startTime) < runtimeMinSec*time.Second {
+		// 保持 maxInFlight 个调用在途
+		for inFlight < maxInFlight {
+			cli.Go("EchoService.ComplexHello", newComplexHelloReq(), done)
+			inFlight++
 			mu.Lock()
-			if err != nil {
-				errorCount++
-				log.Printf("rpc call error: %v", err)
-			} else {
-				successCount++
-			}
+			taskCount++
 			mu.Unlock()
-		}()
-
-		// 控制任务生成速率，避免瞬间创建过多goroutine
-		if taskCount%100 == 0 {
-			time.Sleep(10 * time.Millisecond)
 		}
+		collect()
 	}
 
-	// 等待所有剩余任务完成
-	wg.Wait()
+	// 等待所有剩余调用完成
+	for inFlight > 0 {
+		collect()
+	}
 	duration := time.Since(startTime)
 
 	fmt.Printf("\n\n所有RPC调用完成。\n")
@@ -18,33 +18,9 @@ func (EchoService) Name() string {
 }
 
 func (s *EchoService) Register(r *registry.Registry, codec codec.Codec) {
-	r.ServiceMethods[s.Name()+".SayHello"] = &registry.ServiceMethod{
-		Handler: func(data []byte) ([]byte, error) {
-			var req SayHelloReq
-			if err := codec.Unmarshal(data, &req); err != nil {
-				return nil, err
-			}
-			resp, err := s.SayHello(&req)
-			if err != nil {
-				return nil, err
-			}
-			return codec.Marshal(resp)
-		},
-	}
-
-	r.ServiceMethods[s.Name()+".ComplexHello"] = &registry.ServiceMethod{
-		Handler: func(data []byte) ([]byte, error) {
-			var req ComplexHelloReq
-			if err := codec.Unmarshal(data, &req); err != nil {
-				return nil, err
-			}
-			resp, err := s.ComplexHello(&req)
-			if err != nil {
-				return nil, err
-			}
-			return codec.Marshal(resp)
-		},
-	}
+	r.ServiceMethods[s.Name()+".SayHello"] = registry.NewUnaryMethod(codec, s.SayHello)
+	r.ServiceMethods[s.Name()+".ComplexHello"] = registry.NewUnaryMethod(codec, s.ComplexHello)
+	r.ServiceMethods[s.Name()+".StreamBigData"] = registry.NewStreamMethod(s.StreamBigData)
 }
 
 func (s *EchoService) ComplexHello(req *ComplexHelloReq) (*ComplexHelloResp, error) {
@@ -92,6 +68,27 @@ func (s *EchoService) BigData(req *BigDataReq) (*BigDataResp, error) {
 	}, nil
 }
 
+// 流式大数据测试：按 ChunkSize 分多条消息返回 Size 字节的数据
+func (s *EchoService) StreamBigData(stream registry.ServerStream[BigDataStreamReq, BigDataChunk]) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.ChunkSize <= 0 {
+		req.ChunkSize = 64 * 1024
+	}
+
+	chunk := strings.Repeat("x", req.ChunkSize)
+	for seq, sent := 0, 0; sent < req.Size; seq++ {
+		n := min(req.ChunkSize, req.Size-sent)
+		if err := stream.Send(&BigDataChunk{Seq: seq, Data: chunk[:n]}); err != nil {
+			return err
+		}
+		sent += n
+	}
+	return nil
+}
+
 // 计算密集型测试：执行多次数学运算
 func (s *EchoService) MathOperation(req *MathOperationReq) (*MathOperationResp, error) {
 	if req.Repeat <= 0 {
@@ -47,6 +47,17 @@ type BigDataResp struct {
 	Data      string // 生成的大数据
 }
 
+// 流式大数据测试结构，总大小可以超过单帧的 MaxFrameSize
+type BigDataStreamReq struct {
+	Size      int // 要求返回的总字节数
+	ChunkSize int // 每条消息的字节数
+}
+
+type BigDataChunk struct {
+	Seq  int
+	Data string
+}
+
 // 计算型测试结构
 type MathOperationReq struct {
 	A      int
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"xxrpc/examples/simple/echo"
@@ -12,21 +14,36 @@ import (
 	"xxrpc/server"
 )
 
-func init() {
-	go func() {
-		http.ListenAndServe("localhost:6060", nil)
-	}()
-}
+// reg 收集 RPC 指标，与 pprof 一起在 :6060 上暴露
+var reg = prometheus.NewRegistry()
 
 func main() {
+	// 日志级别可通过 /debug/xxrpc/loglevel 在运行时调整
+	lvl := zap.NewAtomicLevel()
+	logCfg := zap.NewProductionConfig()
+	logCfg.Level = lvl
+	logger, err := logCfg.Build()
+	if err != nil {
+		panic(err)
+	}
+
 	s := server.NewServer(":8888", registry.NewRegister(),
-		server.WithLogger(zap.L().Named("XXRPC")),
+		server.WithLogger(logger.Named("XXRPC")),
+		server.WithLogLevel(lvl),
 		server.WithCodec(&codec.JsoniterCodec{}),
+		server.WithMetrics(reg),
+		server.WithReflection(),
 	)
 	s.Logger().Info("RPC Server listening on :8888")
 
 	s.Register(&echo.EchoService{})
 
+	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	http.Handle("/debug/xxrpc/", http.StripPrefix("/debug/xxrpc", s.AdminHandler()))
+	go func() {
+		http.ListenAndServe("localhost:6060", nil)
+	}()
+
 	if err := s.Start(); err != nil {
 		s.Logger().Fatal("failed to start server", zap.Error(err))
 	}
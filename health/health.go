@@ -0,0 +1,163 @@
+// Package health implements the Health service that every server registers.
+// It reports whether the server as a whole, and each service on it, is ready
+// to take calls.
+package health
+
+import (
+	"fmt"
+	"sync"
+
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+)
+
+// ServiceName is the name the Health service is registered under.
+const ServiceName = "Health"
+
+// Status is the serving status of a service.
+type Status string
+
+const (
+	StatusUnknown        Status = "UNKNOWN"
+	StatusServing        Status = "SERVING"
+	StatusNotServing     Status = "NOT_SERVING"
+	StatusServiceUnknown Status = "SERVICE_UNKNOWN" // sent by Watch for services never registered
+)
+
+// CheckReq names the service to check. The empty name stands for the server
+// as a whole.
+type CheckReq struct {
+	Service string `json:"service"`
+}
+
+type CheckResp struct {
+	Status Status `json:"status"`
+}
+
+// Server is the Health service. It is safe for concurrent use.
+type Server struct {
+	mu       sync.Mutex
+	shutdown bool
+	statuses map[string]Status
+	watchers map[string]map[chan Status]struct{}
+}
+
+// NewServer returns a Health service reporting the server as a whole as
+// SERVING and knowing no other service.
+func NewServer() *Server {
+	return &Server{
+		statuses: map[string]Status{"": StatusServing},
+		watchers: make(map[string]map[chan Status]struct{}),
+	}
+}
+
+func (s *Server) Name() string {
+	return ServiceName
+}
+
+func (s *Server) Register(r *registry.Registry, c codec.Codec) {
+	r.ServiceMethods[ServiceName+".Check"] = registry.NewUnaryMethod(c, s.Check)
+	r.ServiceMethods[ServiceName+".Watch"] = registry.NewStreamMethod(s.Watch)
+}
+
+// Check returns the current status of req.Service, or an error if it is unknown.
+func (s *Server) Check(req *CheckReq) (*CheckResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[req.Service]
+	if !ok {
+		return nil, fmt.Errorf("health: unknown service %q", req.Service)
+	}
+	return &CheckResp{Status: status}, nil
+}
+
+// Watch receives one CheckReq, then sends the status of the service right
+// away and again each time it changes, until the stream ends.
+func (s *Server) Watch(st registry.ServerStream[CheckReq, CheckResp]) error {
+	req, err := st.Recv()
+	if err != nil {
+		return err
+	}
+
+	// buffered so that updates never block; a slow watcher only sees the latest status
+	ch := make(chan Status, 1)
+	s.mu.Lock()
+	status, ok := s.statuses[req.Service]
+	if !ok {
+		status = StatusServiceUnknown
+	}
+	ch <- status
+	if s.watchers[req.Service] == nil {
+		s.watchers[req.Service] = make(map[chan Status]struct{})
+	}
+	s.watchers[req.Service][ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers[req.Service], ch)
+		if len(s.watchers[req.Service]) == 0 {
+			delete(s.watchers, req.Service)
+		}
+		s.mu.Unlock()
+	}()
+
+	var last Status
+	for {
+		select {
+		case <-st.Done():
+			return nil
+		case status := <-ch:
+			if status == last {
+				continue
+			}
+			if err := st.Send(&CheckResp{Status: status}); err != nil {
+				return err
+			}
+			last = status
+		}
+	}
+}
+
+// SetServingStatus sets the status of service and notifies its watchers. It
+// has no effect after Shutdown until Resume is called.
+func (s *Server) SetServingStatus(service string, status Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return
+	}
+	s.setLocked(service, status)
+}
+
+// Shutdown sets every service to NOT_SERVING and ignores further updates.
+// The server calls it when it starts shutting down.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for service := range s.statuses {
+		s.setLocked(service, StatusNotServing)
+	}
+}
+
+// Resume undoes Shutdown, setting every service back to SERVING.
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = false
+	for service := range s.statuses {
+		s.setLocked(service, StatusServing)
+	}
+}
+
+func (s *Server) setLocked(service string, status Status) {
+	s.statuses[service] = status
+	for ch := range s.watchers[service] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
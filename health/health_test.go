@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"xxrpc/registry"
+)
+
+// fakeStream feeds one request to Watch and collects what it sends.
+type fakeStream struct {
+	req  *CheckReq
+	sent chan Status
+	done chan struct{}
+}
+
+func (s *fakeStream) Method() string              { return ServiceName + ".Watch" }
+func (s *fakeStream) Metadata() map[string]string { return nil }
+func (s *fakeStream) Context() context.Context    { return context.Background() }
+func (s *fakeStream) Done() <-chan struct{}       { return s.done }
+
+func (s *fakeStream) SendMsg(m any) error {
+	s.sent <- m.(*CheckResp).Status
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if s.req == nil {
+		return io.EOF
+	}
+	b, _ := json.Marshal(s.req)
+	s.req = nil
+	return json.Unmarshal(b, m)
+}
+
+func next(t *testing.T, ch chan Status) Status {
+	t.Helper()
+	select {
+	case st := <-ch:
+		return st
+	case <-time.After(time.Second):
+		t.Fatal("no status sent")
+		return ""
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := NewServer()
+	if resp, err := s.Check(&CheckReq{}); err != nil || resp.Status != StatusServing {
+		t.Fatalf("server status = %v, %v", resp, err)
+	}
+	if _, err := s.Check(&CheckReq{Service: "Echo"}); err == nil {
+		t.Fatal("unknown service: expected an error")
+	}
+
+	s.SetServingStatus("Echo", StatusServing)
+	s.Shutdown()
+	s.SetServingStatus("Echo", StatusServing) // ignored after Shutdown
+	if resp, _ := s.Check(&CheckReq{Service: "Echo"}); resp.Status != StatusNotServing {
+		t.Fatalf("after Shutdown: %s", resp.Status)
+	}
+	s.Resume()
+	if resp, _ := s.Check(&CheckReq{}); resp.Status != StatusServing {
+		t.Fatalf("after Resume: %s", resp.Status)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := NewServer()
+	st := &fakeStream{req: &CheckReq{Service: "Echo"}, sent: make(chan Status, 4), done: make(chan struct{})}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Watch(registry.ServerStream[CheckReq, CheckResp]{Stream: st})
+	}()
+
+	if got := next(t, st.sent); got != StatusServiceUnknown {
+		t.Fatalf("first status = %s", got)
+	}
+	s.SetServingStatus("Echo", StatusServing)
+	if got := next(t, st.sent); got != StatusServing {
+		t.Fatalf("after SetServingStatus: %s", got)
+	}
+	s.Shutdown()
+	if got := next(t, st.sent); got != StatusNotServing {
+		t.Fatalf("after Shutdown: %s", got)
+	}
+
+	close(st.done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.watchers) != 0 {
+		t.Fatal("watcher not removed")
+	}
+}
@@ -2,23 +2,50 @@ package buffer
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
+// maxPooledCap 超过该容量的 buf 不回池
+const maxPooledCap = 64 * 1024
+
 var bufPool = sync.Pool{
 	New: func() any {
+		allocs.Add(1)
 		// 初始化容量，比如 4KB
 		b := make([]byte, 0, 1024)
 		return &b
 	},
 }
 
+var gets, puts, allocs, dropped atomic.Int64
+
+// Stats 是缓冲池自进程启动以来的计数
+type Stats struct {
+	Gets    int64 `json:"gets"`
+	Puts    int64 `json:"puts"`
+	Allocs  int64 `json:"allocs"`  // 池为空时新分配的次数
+	Dropped int64 `json:"dropped"` // 因容量过大未回池的次数
+}
+
+func GetStats() Stats {
+	return Stats{
+		Gets:    gets.Load(),
+		Puts:    puts.Load(),
+		Allocs:  allocs.Load(),
+		Dropped: dropped.Load(),
+	}
+}
+
 func GetBuffer() *[]byte {
+	gets.Add(1)
 	return bufPool.Get().(*[]byte)
 }
 
 func PutBuffer(b *[]byte) {
+	puts.Add(1)
 	// 可选：防止过大的 buf 回池
-	if cap(*b) > 64*1024 {
+	if cap(*b) > maxPooledCap {
+		dropped.Add(1)
 		return
 	}
 	*b = (*b)[:0] // 清空但不释放
@@ -0,0 +1,39 @@
+// Package cmdutil holds the flag handling shared by the xxrpc commands.
+package cmdutil
+
+import (
+	"fmt"
+	"strings"
+
+	"xxrpc/internal/codec"
+)
+
+// Metadata collects repeated -md key=value flags.
+type Metadata map[string]string
+
+func (m Metadata) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m Metadata) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("metadata %q is not key=value", s)
+	}
+	m[k] = v
+	return nil
+}
+
+var codecs = map[string]codec.Codec{
+	"json":     &codec.JSONCodec{},
+	"jsoniter": &codec.JsoniterCodec{},
+}
+
+// Codec returns the codec named by a -codec flag.
+func Codec(name string) (codec.Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
@@ -21,24 +21,37 @@ var (
 	}
 
 	GetRequest = func() *protocol.Request {
-		return requestPool.Get().(*protocol.Request)
+		req := requestPool.Get().(*protocol.Request)
+		if req.Params == nil {
+			req.Params = buffer.GetBuffer()
+		}
+		return req
 	}
 
 	GetResponse = func() *protocol.Response {
-		return responsePool.Get().(*protocol.Response)
+		resp := responsePool.Get().(*protocol.Response)
+		if resp.Data == nil {
+			resp.Data = buffer.GetBuffer()
+		}
+		return resp
 	}
 
 	PutRequest = func(req *protocol.Request) {
 		req.Method = ""
+		req.Metadata = nil
 		if req.Params != nil {
 			buffer.PutBuffer(req.Params)
+			// the buffer now belongs to the buffer pool, don't keep sharing it
+			req.Params = nil
 		}
 		requestPool.Put(req)
 	}
 
 	PutResponse = func(resp *protocol.Response) {
+		resp.Error = ""
 		if resp.Data != nil {
 			buffer.PutBuffer(resp.Data)
+			resp.Data = nil
 		}
 		responsePool.Put(resp)
 	}
@@ -0,0 +1,861 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xxrpc/internal/codec"
+	"xxrpc/internal/pool"
+	"xxrpc/protocol"
+)
+
+const (
+	// DefaultChunkSize keeps every frame inside the FrameConn's pooled read buffer.
+	DefaultChunkSize = 32 * 1024
+	// DefaultMaxMessageSize limits a message reassembled from continuation frames.
+	DefaultMaxMessageSize = 64 * 1024 * 1024
+	// DefaultMaxPendingRequests caps the unary requests a Conn handles at once.
+	DefaultMaxPendingRequests = 1024
+)
+
+// ConnWindow is the connection-level receive window in bytes. It covers the
+// bodies of requests, responses and stream data together; the receiver grants
+// credit back once a request has been handled or a message has been delivered,
+// so a peer sending faster than handlers drain stops when the window is used up.
+const ConnWindow = 1024 * 1024
+
+var (
+	ErrConnClosed      = errors.New("transport: connection closed")
+	ErrMessageTooLarge = errors.New("transport: message too large")
+	ErrTooManyRequests = errors.New("transport: too many pending requests")
+	ErrPingTimeout     = errors.New("transport: keepalive ping timed out")
+	ErrIdleTimeout     = errors.New("transport: connection idle")
+	ErrNoHandler       = errors.New("transport: peer does not accept calls")
+	ErrStreamReset     = errors.New("transport: stream reset")
+	ErrStreamClosed    = errors.New("transport: stream closed")
+)
+
+// StatusError is the error a peer ended a stream with.
+type StatusError struct {
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Handler serves calls opened by the peer.
+type Handler interface {
+	// ServeRequest fills resp for a unary request.
+	ServeRequest(c *Conn, req *protocol.Request, resp *protocol.Response)
+	// ServeStream runs a stream; the returned error becomes the stream status.
+	ServeStream(c *Conn, s *Stream) error
+	// ServeOneway handles a request that expects no response.
+	ServeOneway(c *Conn, req *protocol.Request)
+}
+
+// PendingCall is an outbound unary call waiting for its response.
+type PendingCall struct {
+	ID   uint32
+	Resp *protocol.Response
+	Err  error
+	Done chan struct{} // closed once Resp or Err is set
+
+	// Batch holds the results of a call started with StartBatch. It is nil if
+	// the batch as a whole failed, in which case Resp carries the error.
+	Batch *protocol.BatchResponse
+
+	onDone func(*PendingCall)
+}
+
+func (pc *PendingCall) finish(resp *protocol.Response, err error) {
+	pc.Resp = resp
+	pc.Err = err
+	close(pc.Done)
+	if pc.onDone != nil {
+		pc.onDone(pc)
+	}
+}
+
+// partialMessage collects the continuation frames of one message.
+type partialMessage struct {
+	buf      []byte
+	size     int
+	tooLarge bool // over MaxMessageSize, remaining chunks are discarded
+}
+
+// Conn multiplexes unary calls and streams in both directions over one FrameConn.
+// Messages larger than ChunkSize are split into continuation frames so that big
+// payloads neither exceed the frame limit nor hold up other calls.
+type Conn struct {
+	fc      *protocol.FrameConn
+	codec   codec.Codec
+	handler Handler
+
+	// ChunkSize, MaxMessageSize and MaxPendingRequests may be changed before
+	// Serve is called. Unary requests beyond MaxPendingRequests are rejected
+	// with ErrTooManyRequests and one-way requests are dropped; zero means no
+	// limit.
+	ChunkSize          int
+	MaxMessageSize     int
+	MaxPendingRequests int
+
+	// PingInterval, when set, pings the peer after that long without reading
+	// a frame and fails the connection with ErrPingTimeout if nothing arrives
+	// within PingTimeout (PingInterval if zero). IdleTimeout, when set, closes
+	// the connection with ErrIdleTimeout once no call or stream has been in
+	// flight, started or finished for that long; pings do not count. Both may
+	// be changed before Serve is called.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	IdleTimeout  time.Duration
+
+	// OnReject, when set, is called with the method, empty if the request
+	// could not be decoded, and the error of every request refused without
+	// running the handler. It runs on the Serve goroutine and must not block.
+	OnReject func(method string, err error)
+
+	lastRead   atomic.Int64 // unix nanos of the last frame read
+	lastActive atomic.Int64 // unix nanos of the last call or stream activity
+
+	partial map[uint32]*partialMessage // only touched by the Serve goroutine
+
+	mu      sync.Mutex
+	nextID  uint32
+	calls   map[uint32]*PendingCall
+	streams map[uint32]*Stream
+	err     error
+
+	flow         *sync.Cond // signalled on connection credit and failure
+	sendWindow   int
+	recvConsumed int // bytes handled locally not yet granted back
+	pending      int // unary requests being handled
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewConn wraps fc. Clients allocate odd stream ids and servers even ones, so
+// both sides can open calls on the same connection. handler may be nil if the
+// peer is not expected to open calls. Serve must be called to start reading.
+func NewConn(fc *protocol.FrameConn, c codec.Codec, client bool, handler Handler) *Conn {
+	nextID := uint32(2)
+	if client {
+		nextID = 1
+	}
+	conn := &Conn{
+		fc:                 fc,
+		codec:              c,
+		handler:            handler,
+		ChunkSize:          DefaultChunkSize,
+		MaxMessageSize:     DefaultMaxMessageSize,
+		MaxPendingRequests: DefaultMaxPendingRequests,
+		partial:            make(map[uint32]*partialMessage),
+		nextID:             nextID,
+		calls:              make(map[uint32]*PendingCall),
+		streams:            make(map[uint32]*Stream),
+		sendWindow:         ConnWindow,
+		done:               make(chan struct{}),
+	}
+	conn.flow = sync.NewCond(&conn.mu)
+	return conn
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.fc.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.fc.LocalAddr()
+}
+
+func (c *Conn) Codec() codec.Codec {
+	return c.codec
+}
+
+// ConnStats is a snapshot of the work in flight on a Conn.
+type ConnStats struct {
+	Calls      int // outbound unary calls waiting for a response
+	Streams    int // open streams in either direction
+	Handling   int // inbound unary, one-way and batch requests being handled
+	SendWindow int // connection credit left for sending
+}
+
+func (c *Conn) Stats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnStats{
+		Calls:      len(c.calls),
+		Streams:    len(c.streams),
+		Handling:   c.pending,
+		SendWindow: c.sendWindow,
+	}
+}
+
+// Done is closed when the connection has failed or been closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, or nil while it is open.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close shuts the connection down. Pending calls and open streams fail with ErrConnClosed.
+func (c *Conn) Close() error {
+	c.fail(ErrConnClosed)
+	return nil
+}
+
+// fail records err, unblocks the reader and fails everything in flight.
+func (c *Conn) fail(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		calls, streams := c.calls, c.streams
+		c.calls = make(map[uint32]*PendingCall)
+		c.streams = make(map[uint32]*Stream)
+		c.flow.Broadcast()
+		c.mu.Unlock()
+
+		close(c.done)
+		c.fc.Shutdown()
+		for _, pc := range calls {
+			pc.finish(nil, err)
+		}
+		for _, s := range streams {
+			s.finish(err)
+		}
+	})
+}
+
+func (c *Conn) allocID() uint32 {
+	id := c.nextID
+	c.nextID += 2
+	return id
+}
+
+// write sends one message, splitting bodies larger than ChunkSize into
+// continuation frames. The write lock is taken per frame, so chunks of
+// different calls interleave on the wire. Requests, responses and stream data
+// first wait for connection credit; like stream credit it may be overdrawn by
+// one message. write must not block the Serve goroutine, which is the one
+// receiving credit, so messages sent from there go through a goroutine.
+func (c *Conn) write(h protocol.Header, body []byte) error {
+	if flowControlled(h.Type) && len(body) > 0 {
+		c.mu.Lock()
+		for c.sendWindow <= 0 && c.err == nil {
+			c.flow.Wait()
+		}
+		if err := c.err; err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		c.sendWindow -= len(body)
+		c.mu.Unlock()
+	}
+
+	for c.ChunkSize > 0 && len(body) > c.ChunkSize {
+		chunk := h
+		chunk.Flags |= protocol.FlagMore
+		if err := c.writeFrame(chunk, body[:c.ChunkSize]); err != nil {
+			return err
+		}
+		body = body[c.ChunkSize:]
+	}
+	return c.writeFrame(h, body)
+}
+
+// writeFrame writes one frame. A failed write may leave part of a frame on the
+// wire, so it fails the connection.
+func (c *Conn) writeFrame(h protocol.Header, body []byte) error {
+	err := c.fc.WriteMessage(h, body)
+	if err != nil {
+		c.fail(err)
+	}
+	return err
+}
+
+func flowControlled(t protocol.FrameType) bool {
+	return t == protocol.FrameRequest || t == protocol.FrameResponse || t == protocol.FrameData
+}
+
+// release returns n bytes of connection credit to the peer, batched so that
+// window updates are sent once half the window has been handled.
+func (c *Conn) release(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.recvConsumed += n
+	grant := 0
+	if c.recvConsumed >= ConnWindow/2 {
+		grant, c.recvConsumed = c.recvConsumed, 0
+	}
+	c.mu.Unlock()
+
+	if grant > 0 {
+		var body [4]byte
+		binary.BigEndian.PutUint32(body[:], uint32(grant))
+		c.write(protocol.Header{Type: protocol.FrameWindowUpdate}, body[:])
+	}
+}
+
+// Serve reads frames until the connection fails and returns the read error.
+func (c *Conn) Serve() error {
+	defer c.fc.Close()
+
+	c.lastRead.Store(time.Now().UnixNano())
+	c.touch()
+	if c.PingInterval > 0 || c.IdleTimeout > 0 {
+		go c.keepalive()
+	}
+
+	for {
+		h, body, err := c.fc.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			return err
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+		if h.Type != protocol.FramePing && h.Type != protocol.FramePong {
+			c.touch()
+		}
+		if err := c.reassemble(h, body); err != nil {
+			c.fail(err)
+			return err
+		}
+	}
+}
+
+// touch records call or stream activity for IdleTimeout.
+func (c *Conn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// keepalive pings a quiet peer and reaps the connection when pongs stop or
+// when it has been idle for IdleTimeout.
+func (c *Conn) keepalive() {
+	pingTimeout := c.PingTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = c.PingInterval
+	}
+	tick := time.Duration(0)
+	for _, d := range []time.Duration{c.PingInterval, pingTimeout, c.IdleTimeout} {
+		if d > 0 && (tick == 0 || d < tick) {
+			tick = d
+		}
+	}
+	// tiny intervals would spin, and NewTicker panics once tick/2 rounds to 0
+	ticker := time.NewTicker(max(tick/2, time.Millisecond))
+	defer ticker.Stop()
+
+	var pingAt time.Time // zero while no ping is outstanding
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			lastRead := time.Unix(0, c.lastRead.Load())
+
+			if c.PingInterval > 0 {
+				switch {
+				case !pingAt.IsZero() && !lastRead.Before(pingAt):
+					pingAt = time.Time{}
+				case !pingAt.IsZero() && now.Sub(pingAt) > pingTimeout:
+					c.fail(ErrPingTimeout)
+					return
+				}
+				if pingAt.IsZero() && now.Sub(lastRead) >= c.PingInterval {
+					var body [8]byte
+					binary.BigEndian.PutUint64(body[:], uint64(now.UnixNano()))
+					pingAt = now
+					c.write(protocol.Header{Type: protocol.FramePing}, body[:])
+				}
+			}
+
+			if c.IdleTimeout > 0 {
+				c.mu.Lock()
+				busy := len(c.calls) > 0 || len(c.streams) > 0 || c.pending > 0
+				c.mu.Unlock()
+				// calls that started and finished between ticks only show in lastActive
+				if busy {
+					c.touch()
+				} else if now.Sub(time.Unix(0, c.lastActive.Load())) >= c.IdleTimeout {
+					c.fail(ErrIdleTimeout)
+					return
+				}
+			}
+		}
+	}
+}
+
+// reassemble buffers continuation frames and dispatches complete messages.
+func (c *Conn) reassemble(h protocol.Header, body []byte) error {
+	if !flowControlled(h.Type) {
+		// control frames are never split and may arrive between chunks
+		return c.dispatch(h, body, false)
+	}
+
+	p, ok := c.partial[h.StreamID]
+	if !ok && !h.Has(protocol.FlagMore) {
+		return c.dispatch(h, body, false)
+	}
+	if !ok {
+		p = &partialMessage{}
+		c.partial[h.StreamID] = p
+	}
+
+	p.size += len(body)
+	if !p.tooLarge {
+		if p.size > c.MaxMessageSize {
+			p.tooLarge = true
+			p.buf = nil
+		} else {
+			p.buf = append(p.buf, body...)
+		}
+	}
+	if h.Has(protocol.FlagMore) {
+		return nil
+	}
+
+	delete(c.partial, h.StreamID)
+	h.Flags &^= protocol.FlagMore
+	if p.tooLarge {
+		return c.rejectTooLarge(h, p.size)
+	}
+	return c.dispatch(h, p.buf, true)
+}
+
+// rejectTooLarge fails the call whose message of size bytes exceeded MaxMessageSize.
+func (c *Conn) rejectTooLarge(h protocol.Header, size int) error {
+	c.release(size)
+	switch h.Type {
+	case protocol.FrameRequest:
+		return c.rejectRequest(h, "", ErrMessageTooLarge)
+	case protocol.FrameResponse:
+		c.mu.Lock()
+		pc, ok := c.calls[h.StreamID]
+		delete(c.calls, h.StreamID)
+		c.mu.Unlock()
+		if ok {
+			pc.finish(nil, ErrMessageTooLarge)
+		} else if s := c.removeStream(h.StreamID); s != nil {
+			s.finish(ErrMessageTooLarge)
+		}
+	case protocol.FrameData:
+		if s := c.removeStream(h.StreamID); s != nil {
+			s.finish(ErrMessageTooLarge)
+			return c.write(protocol.Header{Type: protocol.FrameReset, StreamID: h.StreamID}, nil)
+		}
+	}
+	return nil
+}
+
+// dispatch handles one inbound message. Unless owned is set, body is only
+// valid until it returns.
+func (c *Conn) dispatch(h protocol.Header, body []byte, owned bool) error {
+	switch h.Type {
+	case protocol.FrameRequest:
+		return c.handleRequest(h, body)
+	case protocol.FrameResponse:
+		c.release(len(body))
+		return c.handleResponse(h, body)
+	case protocol.FrameData:
+		// the stream window bounds what is buffered, so credit goes back on delivery
+		c.release(len(body))
+		if s := c.stream(h.StreamID); s != nil {
+			data := body
+			if !owned {
+				data = make([]byte, len(body))
+				copy(data, body)
+			}
+			s.push(data, h.Has(protocol.FlagEndStream))
+		}
+	case protocol.FrameReset:
+		delete(c.partial, h.StreamID)
+		if s := c.removeStream(h.StreamID); s != nil {
+			s.finish(ErrStreamReset)
+		}
+	case protocol.FrameWindowUpdate:
+		if len(body) < 4 {
+			break
+		}
+		n := int(binary.BigEndian.Uint32(body))
+		if h.StreamID == 0 {
+			c.mu.Lock()
+			c.sendWindow += n
+			c.flow.Broadcast()
+			c.mu.Unlock()
+		} else if s := c.stream(h.StreamID); s != nil {
+			s.grant(n)
+		}
+	case protocol.FramePing:
+		return c.write(protocol.Header{Type: protocol.FramePong, StreamID: h.StreamID}, body)
+	case protocol.FramePong:
+		// liveness is tracked by Serve for every frame
+	}
+	// unknown frame types are ignored so newer peers can add their own
+	return nil
+}
+
+func (c *Conn) handleRequest(h protocol.Header, body []byte) error {
+	if h.Has(protocol.FlagBatch) {
+		return c.handleBatch(h, body)
+	}
+
+	// a malformed request only fails its own call, not the connection
+	req := pool.GetRequest()
+	if err := c.codec.Unmarshal(body, req); err != nil {
+		pool.PutRequest(req)
+		c.release(len(body))
+		return c.rejectRequest(h, "", err)
+	}
+
+	if c.handler == nil {
+		method := req.Method
+		pool.PutRequest(req)
+		c.release(len(body))
+		return c.rejectRequest(h, method, ErrNoHandler)
+	}
+
+	if h.Has(protocol.FlagStream) {
+		s := newStream(c, h.StreamID, req.Method, req.Metadata)
+		pool.PutRequest(req)
+		c.mu.Lock()
+		c.streams[h.StreamID] = s
+		c.mu.Unlock()
+		c.release(len(body))
+		go c.serveStream(s)
+		return nil
+	}
+
+	if !c.acquire() {
+		method := req.Method
+		pool.PutRequest(req)
+		c.release(len(body))
+		return c.rejectRequest(h, method, ErrTooManyRequests)
+	}
+
+	if h.Has(protocol.FlagOneway) {
+		go c.serveOneway(req, len(body))
+		return nil
+	}
+	go c.serveRequest(h.StreamID, req, len(body))
+	return nil
+}
+
+// acquire takes a slot for a unary, one-way or batch request, reporting false
+// if MaxPendingRequests has been reached. finishRequest gives the slot back.
+func (c *Conn) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.MaxPendingRequests > 0 && c.pending >= c.MaxPendingRequests {
+		return false
+	}
+	c.pending++
+	return true
+}
+
+// finishRequest gives back the slot of a request of size bytes and its connection credit.
+func (c *Conn) finishRequest(size int) {
+	c.mu.Lock()
+	c.pending--
+	c.mu.Unlock()
+	c.touch()
+	c.release(size)
+}
+
+// handleBatch runs a batch as one request: it takes one pending slot and is
+// answered with a single response. serveBatch takes more slots for the items
+// it runs in parallel.
+func (c *Conn) handleBatch(h protocol.Header, body []byte) error {
+	var batch protocol.BatchRequest
+	if err := c.codec.Unmarshal(body, &batch); err != nil {
+		c.release(len(body))
+		return c.rejectRequest(h, "", err)
+	}
+	if c.handler == nil {
+		c.release(len(body))
+		return c.rejectRequest(h, "", ErrNoHandler)
+	}
+	if !c.acquire() {
+		c.release(len(body))
+		return c.rejectRequest(h, "", ErrTooManyRequests)
+	}
+
+	go c.serveBatch(h.StreamID, &batch, len(body))
+	return nil
+}
+
+func (c *Conn) serveBatch(id uint32, batch *protocol.BatchRequest, size int) {
+	resps := make([]protocol.Response, len(batch.Requests))
+	if batch.Sequential {
+		for i := range batch.Requests {
+			c.handler.ServeRequest(c, &batch.Requests[i], &resps[i])
+		}
+	} else {
+		// items get a goroutine only while pending slots are free, the rest
+		// run on this one, so a batch never exceeds MaxPendingRequests
+		var wg sync.WaitGroup
+		last := len(batch.Requests) - 1
+		for i := range batch.Requests {
+			if i == last || !c.acquire() {
+				c.handler.ServeRequest(c, &batch.Requests[i], &resps[i])
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c.handler.ServeRequest(c, &batch.Requests[i], &resps[i])
+				c.finishRequest(0)
+			}(i)
+		}
+		wg.Wait()
+	}
+	c.finishRequest(size)
+
+	data, err := c.codec.Marshal(&protocol.BatchResponse{Responses: resps})
+	if err != nil {
+		c.writeResponse(id, 0, &protocol.Response{Error: err.Error()})
+		return
+	}
+	c.write(protocol.Header{Type: protocol.FrameResponse, Flags: protocol.FlagBatch, StreamID: id}, data)
+}
+
+// rejectRequest refuses a request for method without running a handler:
+// streams are reset, unary calls get err as their response and one-way calls
+// are dropped.
+func (c *Conn) rejectRequest(h protocol.Header, method string, err error) error {
+	if c.OnReject != nil {
+		c.OnReject(method, err)
+	}
+	if h.Has(protocol.FlagOneway) {
+		return nil
+	}
+	if h.Has(protocol.FlagStream) {
+		return c.write(protocol.Header{Type: protocol.FrameReset, StreamID: h.StreamID}, nil)
+	}
+	go c.writeResponse(h.StreamID, 0, &protocol.Response{Error: err.Error()})
+	return nil
+}
+
+// serveRequest runs the handler for a unary request of size bytes. Its
+// connection credit is only returned once the handler is done.
+func (c *Conn) serveRequest(id uint32, req *protocol.Request, size int) {
+	resp := pool.GetResponse()
+	c.handler.ServeRequest(c, req, resp)
+	pool.PutRequest(req)
+	c.finishRequest(size)
+
+	c.writeResponse(id, 0, resp)
+	pool.PutResponse(resp)
+}
+
+func (c *Conn) serveOneway(req *protocol.Request, size int) {
+	c.handler.ServeOneway(c, req)
+	pool.PutRequest(req)
+	c.finishRequest(size)
+}
+
+func (c *Conn) serveStream(s *Stream) {
+	err := c.handler.ServeStream(c, s)
+
+	// the peer may have reset the stream while the handler was running
+	if c.removeStream(s.id) == nil {
+		return
+	}
+	s.finish(ErrStreamClosed)
+	resp := protocol.Response{}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	c.writeResponse(s.id, protocol.FlagEndStream, &resp)
+}
+
+func (c *Conn) writeResponse(id uint32, flags uint8, resp *protocol.Response) error {
+	data, err := c.codec.Marshal(resp)
+	if err != nil {
+		data, _ = c.codec.Marshal(&protocol.Response{Error: err.Error()})
+	}
+	return c.write(protocol.Header{Type: protocol.FrameResponse, Flags: flags, StreamID: id}, data)
+}
+
+func (c *Conn) handleResponse(h protocol.Header, body []byte) error {
+	if h.Has(protocol.FlagBatch) {
+		return c.handleBatchResponse(h, body)
+	}
+
+	var resp protocol.Response
+	if err := c.codec.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	pc, ok := c.calls[h.StreamID]
+	delete(c.calls, h.StreamID)
+	c.mu.Unlock()
+	if ok {
+		pc.finish(&resp, nil)
+		return nil
+	}
+
+	if s := c.removeStream(h.StreamID); s != nil {
+		var status error
+		if resp.Error != "" {
+			status = &StatusError{Message: resp.Error}
+		}
+		s.finish(status)
+	}
+	return nil
+}
+
+func (c *Conn) handleBatchResponse(h protocol.Header, body []byte) error {
+	var batch protocol.BatchResponse
+	if err := c.codec.Unmarshal(body, &batch); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	pc, ok := c.calls[h.StreamID]
+	delete(c.calls, h.StreamID)
+	c.mu.Unlock()
+	if ok {
+		pc.Batch = &batch
+		pc.finish(&protocol.Response{}, nil)
+	}
+	return nil
+}
+
+func (c *Conn) stream(id uint32) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[id]
+}
+
+func (c *Conn) removeStream(id uint32) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.streams[id]
+	if !ok {
+		return nil
+	}
+	delete(c.streams, id)
+	c.touch()
+	return s
+}
+
+// StartCall sends a unary request. The caller waits on the returned call's Done channel.
+func (c *Conn) StartCall(req *protocol.Request) (*PendingCall, error) {
+	return c.StartCallFunc(req, nil)
+}
+
+// StartCallFunc is StartCall with fn called once the call has finished. fn
+// runs on the connection's read goroutine and must not block. It is not
+// called if StartCallFunc returns an error.
+func (c *Conn) StartCallFunc(req *protocol.Request, fn func(*PendingCall)) (*PendingCall, error) {
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.startCall(0, data, fn)
+}
+
+// StartBatch sends several requests in one frame. The results arrive together
+// in the returned call's Batch once Done is closed.
+func (c *Conn) StartBatch(batch *protocol.BatchRequest) (*PendingCall, error) {
+	data, err := c.codec.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	return c.startCall(protocol.FlagBatch, data, nil)
+}
+
+func (c *Conn) startCall(flags uint8, data []byte, fn func(*PendingCall)) (*PendingCall, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	pc := &PendingCall{ID: c.allocID(), Done: make(chan struct{}), onDone: fn}
+	c.calls[pc.ID] = pc
+	c.mu.Unlock()
+	c.touch()
+
+	if err := c.write(protocol.Header{Type: protocol.FrameRequest, Flags: flags, StreamID: pc.ID}, data); err != nil {
+		c.mu.Lock()
+		_, pending := c.calls[pc.ID]
+		delete(c.calls, pc.ID)
+		c.mu.Unlock()
+		if pending {
+			return nil, err
+		}
+		// the connection failed meanwhile and has already finished the call
+	}
+	return pc, nil
+}
+
+// SendOneway sends a request that expects no response. It returns once the
+// request has been written.
+func (c *Conn) SendOneway(req *protocol.Request) error {
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	// an id is still needed to keep its continuation frames apart
+	id := c.allocID()
+	c.mu.Unlock()
+	c.touch()
+
+	return c.write(protocol.Header{Type: protocol.FrameRequest, Flags: protocol.FlagOneway, StreamID: id}, data)
+}
+
+// CancelCall finishes pc with err if it is still waiting for its response,
+// which is then discarded. The peer is not told and completes the call anyway.
+func (c *Conn) CancelCall(pc *PendingCall, err error) {
+	c.mu.Lock()
+	_, pending := c.calls[pc.ID]
+	delete(c.calls, pc.ID)
+	c.mu.Unlock()
+	if pending {
+		pc.finish(nil, err)
+	}
+}
+
+// NewStream opens a stream to method on the peer.
+func (c *Conn) NewStream(method string, md map[string]string) (*Stream, error) {
+	data, err := c.codec.Marshal(&protocol.Request{Method: method, Metadata: md})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	s := newStream(c, c.allocID(), method, md)
+	c.streams[s.id] = s
+	c.mu.Unlock()
+	c.touch()
+
+	h := protocol.Header{Type: protocol.FrameRequest, Flags: protocol.FlagStream, StreamID: s.id}
+	if err := c.write(h, data); err != nil {
+		c.removeStream(s.id)
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,568 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xxrpc/internal/codec"
+	"xxrpc/protocol"
+)
+
+type echoHandler struct {
+	oneway chan string
+}
+
+func (echoHandler) ServeRequest(_ *Conn, req *protocol.Request, resp *protocol.Response) {
+	if req.Method == "fail" {
+		resp.Error = "failed"
+		return
+	}
+	data := append([]byte(req.Method+":"), *req.Params...)
+	resp.Data = &data
+}
+
+// ServeOneway records the method on oneway, if set.
+func (h echoHandler) ServeOneway(_ *Conn, req *protocol.Request) {
+	if h.oneway != nil {
+		h.oneway <- req.Method
+	}
+}
+
+// ServeStream echoes every message back and reports an error if asked to.
+func (echoHandler) ServeStream(_ *Conn, s *Stream) error {
+	for {
+		var msg string
+		if err := s.RecvMsg(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := s.SendMsg(msg); err != nil {
+			return err
+		}
+	}
+	if s.Method() == "fail" {
+		return errors.New("stream failed")
+	}
+	return nil
+}
+
+func newPipe(t *testing.T) (client, server *Conn) {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	client = NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server = NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	go client.Serve()
+	go server.Serve()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestUnary(t *testing.T) {
+	client, _ := newPipe(t)
+
+	params := []byte("hi")
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || string(*pc.Resp.Data) != "echo:hi" {
+		t.Fatalf("response = %+v, %v", pc.Resp, pc.Err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	client, _ := newPipe(t)
+
+	s, err := client.NewStream("echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more than a window's worth of data, so credit has to flow back
+	const n = 100
+	big := strings.Repeat("x", StreamWindow/10)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := s.SendMsg(big); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		s.CloseSend()
+	}()
+
+	for i := 0; i < n; i++ {
+		var msg string
+		if err := s.RecvMsg(&msg); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if len(msg) != len(big) {
+			t.Fatalf("message %d has %d bytes, want %d", i, len(msg), len(big))
+		}
+	}
+	var msg string
+	if err := s.RecvMsg(&msg); err != io.EOF {
+		t.Fatalf("RecvMsg after last message = %v, want io.EOF", err)
+	}
+}
+
+func TestStreamStatus(t *testing.T) {
+	client, _ := newPipe(t)
+
+	s, err := client.NewStream("fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CloseSend()
+	var msg string
+	if err := s.RecvMsg(&msg); err == nil || err.Error() != "stream failed" {
+		t.Fatalf("RecvMsg = %v, want the handler's error", err)
+	}
+}
+
+func TestCloseFailsPending(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	go client.Serve()
+	// drain the request without answering it
+	go io.Copy(io.Discard, c2)
+
+	pc, err := client.StartCall(&protocol.Request{Method: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	<-pc.Done
+	if pc.Err != ErrConnClosed {
+		t.Fatalf("pending call error = %v, want ErrConnClosed", pc.Err)
+	}
+}
+
+func TestCancelCall(t *testing.T) {
+	c1, c2 := net.Pipe()
+	h := blockHandler{release: make(chan struct{})}
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, h)
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	params := []byte("hi")
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCanceled := errors.New("canceled")
+	client.CancelCall(pc, errCanceled)
+	<-pc.Done
+	if pc.Err != errCanceled {
+		t.Fatalf("canceled call error = %v", pc.Err)
+	}
+
+	// the late response is dropped and the connection keeps working
+	close(h.release)
+	pc, err = client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || pc.Resp.Error != "" {
+		t.Fatalf("response after cancel = %+v, %v", pc.Resp, pc.Err)
+	}
+	client.CancelCall(pc, errCanceled)
+	if pc.Err != nil {
+		t.Fatalf("cancelling a finished call changed its error to %v", pc.Err)
+	}
+}
+
+func TestChunkedMessage(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	client.ChunkSize, server.ChunkSize = 1024, 1024
+	server.MaxMessageSize = 64 * 1024
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	params := []byte(strings.Repeat("x", 20*1024))
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || string(*pc.Resp.Data) != "echo:"+string(params) {
+		t.Fatalf("chunked call failed: %v", pc.Err)
+	}
+
+	params = []byte(strings.Repeat("x", 100*1024))
+	pc, err = client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || pc.Resp.Error != ErrMessageTooLarge.Error() {
+		t.Fatalf("oversized call = %+v, %v", pc.Resp, pc.Err)
+	}
+}
+
+// blockHandler holds every unary request until release is closed.
+type blockHandler struct {
+	echoHandler
+	release chan struct{}
+}
+
+func (h blockHandler) ServeRequest(c *Conn, req *protocol.Request, resp *protocol.Response) {
+	<-h.release
+	h.echoHandler.ServeRequest(c, req, resp)
+}
+
+func TestBackpressure(t *testing.T) {
+	c1, c2 := net.Pipe()
+	h := blockHandler{release: make(chan struct{})}
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, h)
+	server.MaxPendingRequests = 2
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	// a held request larger than the connection window leaves no credit
+	params := []byte(strings.Repeat("x", ConnWindow))
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := []*PendingCall{pc}
+
+	started := make(chan *PendingCall)
+	go func() {
+		small := []byte("hi")
+		pc, _ := client.StartCall(&protocol.Request{Method: "echo", Params: &small})
+		started <- pc
+	}()
+	select {
+	case <-started:
+		t.Fatal("call was sent without connection credit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(h.release)
+	calls = append(calls, <-started)
+	for _, pc := range calls {
+		<-pc.Done
+		if pc.Err != nil || pc.Resp.Error != "" {
+			t.Fatalf("response = %+v, %v", pc.Resp, pc.Err)
+		}
+	}
+}
+
+func TestMaxPendingRequests(t *testing.T) {
+	c1, c2 := net.Pipe()
+	h := blockHandler{release: make(chan struct{})}
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, h)
+	server.MaxPendingRequests = 1
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	params := []byte("hi")
+	first, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-second.Done
+	if second.Err != nil || second.Resp.Error != ErrTooManyRequests.Error() {
+		t.Fatalf("second response = %+v, %v", second.Resp, second.Err)
+	}
+
+	close(h.release)
+	<-first.Done
+	if first.Err != nil || first.Resp.Error != "" {
+		t.Fatalf("first response = %+v, %v", first.Resp, first.Err)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	client.PingInterval = 20 * time.Millisecond
+	go client.Serve()
+	defer client.Close()
+
+	// the peer reads everything but never answers
+	go io.Copy(io.Discard, c2)
+	defer c2.Close()
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not failed")
+	}
+	if client.Err() != ErrPingTimeout {
+		t.Fatalf("err = %v", client.Err())
+	}
+}
+
+func TestTinyPingInterval(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	client.PingInterval = time.Nanosecond
+	client.PingTimeout = time.Second
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	params := []byte("hi")
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || pc.Resp.Error != "" {
+		t.Fatalf("response = %+v, %v", pc.Resp, pc.Err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	client.PingInterval = 10 * time.Millisecond
+	server.IdleTimeout = 100 * time.Millisecond
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+
+	// pings keep the connection alive but do not count as activity
+	params := []byte("hi")
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil {
+		t.Fatal(pc.Err)
+	}
+
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not closed")
+	}
+	if server.Err() != ErrIdleTimeout {
+		t.Fatalf("err = %v", server.Err())
+	}
+	<-client.Done()
+}
+
+func TestIdleTimeoutActive(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	server.IdleTimeout = 100 * time.Millisecond
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	// short calls that finish between keepalive ticks keep the connection open
+	params := []byte("hi")
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-pc.Done
+		if pc.Err != nil {
+			t.Fatal(pc.Err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := server.Err(); err != nil {
+		t.Fatalf("active connection closed: %v", err)
+	}
+}
+
+func TestMalformedRequest(t *testing.T) {
+	c1, c2 := net.Pipe()
+	fc := protocol.NewFrameConn(c1)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, echoHandler{})
+	go server.Serve()
+	defer server.Close()
+	defer fc.Close()
+
+	// an undecodable request and batch are answered with an error, the connection stays up
+	for _, flags := range []uint8{0, protocol.FlagBatch} {
+		if err := fc.WriteMessage(protocol.Header{Type: protocol.FrameRequest, Flags: flags, StreamID: 1}, []byte("{bad")); err != nil {
+			t.Fatal(err)
+		}
+		h, body, err := fc.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp protocol.Response
+		if err := (&codec.JsoniterCodec{}).Unmarshal(body, &resp); err != nil || h.Type != protocol.FrameResponse || resp.Error == "" {
+			t.Fatalf("flags %d: got %v %s, %v", flags, h.Type, body, err)
+		}
+	}
+	if err := server.Err(); err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+}
+
+func TestOneway(t *testing.T) {
+	c1, c2 := net.Pipe()
+	h := echoHandler{oneway: make(chan string, 1)}
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, h)
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	params := []byte("hi")
+	if err := client.SendOneway(&protocol.Request{Method: "notify", Params: &params}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case method := <-h.oneway:
+		if method != "notify" {
+			t.Fatalf("method = %q", method)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oneway request not served")
+	}
+
+	// no response arrives, so a following call gets its own response
+	pc, err := client.StartCall(&protocol.Request{Method: "echo", Params: &params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || string(*pc.Resp.Data) != "echo:hi" {
+		t.Fatalf("response = %+v, %v", pc.Resp, pc.Err)
+	}
+}
+
+func TestStartCallFunc(t *testing.T) {
+	client, _ := newPipe(t)
+
+	done := make(chan *PendingCall, 3)
+	for i := 0; i < cap(done); i++ {
+		params := []byte("hi")
+		if _, err := client.StartCallFunc(&protocol.Request{Method: "echo", Params: &params}, func(pc *PendingCall) {
+			done <- pc
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < cap(done); i++ {
+		pc := <-done
+		if pc.Err != nil || string(*pc.Resp.Data) != "echo:hi" {
+			t.Fatalf("response = %+v, %v", pc.Resp, pc.Err)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	client, _ := newPipe(t)
+
+	var batch protocol.BatchRequest
+	for _, method := range []string{"a", "fail", "b"} {
+		params := []byte("hi")
+		batch.Requests = append(batch.Requests, protocol.Request{Method: method, Params: &params})
+	}
+	pc, err := client.StartBatch(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || pc.Batch == nil || len(pc.Batch.Responses) != 3 {
+		t.Fatalf("batch = %+v, %v", pc.Batch, pc.Err)
+	}
+	resps := pc.Batch.Responses
+	if string(*resps[0].Data) != "a:hi" || resps[1].Error != "failed" || string(*resps[2].Data) != "b:hi" {
+		t.Fatalf("responses = %+v", resps)
+	}
+}
+
+// concHandler records how many unary requests run at once.
+type concHandler struct {
+	echoHandler
+	mu        *sync.Mutex
+	cur, peak *int
+}
+
+func (h concHandler) ServeRequest(c *Conn, req *protocol.Request, resp *protocol.Response) {
+	h.mu.Lock()
+	*h.cur++
+	*h.peak = max(*h.peak, *h.cur)
+	h.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	h.echoHandler.ServeRequest(c, req, resp)
+	h.mu.Lock()
+	*h.cur--
+	h.mu.Unlock()
+}
+
+func TestBatchPendingLimit(t *testing.T) {
+	var cur, peak int
+	h := concHandler{mu: new(sync.Mutex), cur: &cur, peak: &peak}
+	c1, c2 := net.Pipe()
+	client := NewConn(protocol.NewFrameConn(c1), &codec.JsoniterCodec{}, true, nil)
+	server := NewConn(protocol.NewFrameConn(c2), &codec.JsoniterCodec{}, false, h)
+	server.MaxPendingRequests = 3
+	go client.Serve()
+	go server.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	var batch protocol.BatchRequest
+	for i := 0; i < 20; i++ {
+		params := []byte(strconv.Itoa(i))
+		batch.Requests = append(batch.Requests, protocol.Request{Method: "echo", Params: &params})
+	}
+	pc, err := client.StartBatch(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Done
+	if pc.Err != nil || pc.Batch == nil || len(pc.Batch.Responses) != len(batch.Requests) {
+		t.Fatalf("batch = %+v, %v", pc.Batch, pc.Err)
+	}
+	for i, resp := range pc.Batch.Responses {
+		if want := "echo:" + strconv.Itoa(i); resp.Error != "" || string(*resp.Data) != want {
+			t.Fatalf("response %d = %+v, want %s", i, resp, want)
+		}
+	}
+	if peak > server.MaxPendingRequests {
+		t.Fatalf("%d batch items ran at once, MaxPendingRequests is %d", peak, server.MaxPendingRequests)
+	}
+	if peak < 2 {
+		t.Fatalf("batch items did not run in parallel")
+	}
+}
@@ -0,0 +1,221 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"xxrpc/protocol"
+)
+
+// StreamWindow is the per-stream receive window in bytes. A sender may keep
+// sending while it has credit left; the receiver grants credit back as the
+// application consumes messages.
+const StreamWindow = 256 * 1024
+
+// Stream is one bidirectional stream on a Conn. SendMsg and RecvMsg may be
+// called from different goroutines, but each must not be called concurrently
+// with itself.
+type Stream struct {
+	conn     *Conn
+	id       uint32
+	method   string
+	metadata map[string]string
+	ctx      context.Context // set by the handler before serving, see SetContext
+
+	mu         sync.Mutex
+	cond       *sync.Cond // signalled on data, credit and termination
+	recvq      [][]byte
+	recvDone   bool // peer half-closed, no more data will arrive
+	consumed   int  // bytes read by the application not yet granted back
+	sendWindow int
+	sendClosed bool
+	finished   bool
+	finErr     error         // final status; nil means the stream ended normally
+	done       chan struct{} // closed once finished
+}
+
+func newStream(c *Conn, id uint32, method string, md map[string]string) *Stream {
+	s := &Stream{
+		conn:       c,
+		id:         id,
+		method:     method,
+		metadata:   md,
+		sendWindow: StreamWindow,
+		done:       make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) Method() string {
+	return s.method
+}
+
+func (s *Stream) Metadata() map[string]string {
+	return s.metadata
+}
+
+// Context returns the context set with SetContext, or context.Background.
+func (s *Stream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// SetContext sets the context returned by Context. It must be called before
+// the stream is handed to the application.
+func (s *Stream) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *Stream) Conn() *Conn {
+	return s.conn
+}
+
+// SendMsg encodes and sends m, blocking while the peer's window is exhausted.
+// It returns io.EOF if the stream ended normally before m could be sent.
+func (s *Stream) SendMsg(m any) error {
+	data, err := s.conn.codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for s.sendWindow <= 0 && !s.finished && !s.sendClosed {
+		s.cond.Wait()
+	}
+	if err := s.sendErrLocked(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	// a message larger than the remaining window may overdraw it, otherwise
+	// messages bigger than the window could never be sent
+	s.sendWindow -= len(data)
+	s.mu.Unlock()
+
+	return s.conn.write(protocol.Header{Type: protocol.FrameData, StreamID: s.id}, data)
+}
+
+func (s *Stream) sendErrLocked() error {
+	switch {
+	case s.finished && s.finErr != nil:
+		return s.finErr
+	case s.finished:
+		return io.EOF
+	case s.sendClosed:
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+// RecvMsg receives the next message into m. It returns io.EOF once the peer
+// has half-closed or ended the stream normally and all messages were read.
+func (s *Stream) RecvMsg(m any) error {
+	s.mu.Lock()
+	for len(s.recvq) == 0 && !s.recvDone && !s.finished {
+		s.cond.Wait()
+	}
+	if len(s.recvq) == 0 {
+		err := s.finErr
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	data := s.recvq[0]
+	s.recvq[0] = nil
+	s.recvq = s.recvq[1:]
+	s.consumed += len(data)
+	grant := 0
+	if s.consumed >= StreamWindow/2 && !s.recvDone && !s.finished {
+		grant, s.consumed = s.consumed, 0
+	}
+	s.mu.Unlock()
+
+	if grant > 0 {
+		var body [4]byte
+		binary.BigEndian.PutUint32(body[:], uint32(grant))
+		s.conn.write(protocol.Header{Type: protocol.FrameWindowUpdate, StreamID: s.id}, body[:])
+	}
+	return s.conn.codec.Unmarshal(data, m)
+}
+
+// CloseSend half-closes the stream: the peer's RecvMsg returns io.EOF after
+// the messages already sent.
+func (s *Stream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed || s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	return s.conn.write(protocol.Header{Type: protocol.FrameData, Flags: protocol.FlagEndStream, StreamID: s.id}, nil)
+}
+
+// Close aborts the stream if it has not ended yet and resets it on the peer.
+func (s *Stream) Close() error {
+	return s.Abort(ErrStreamClosed)
+}
+
+// Abort is Close with err as the stream's status.
+func (s *Stream) Abort(err error) error {
+	if s.conn.removeStream(s.id) == nil {
+		return nil
+	}
+	s.finish(err)
+	return s.conn.write(protocol.Header{Type: protocol.FrameReset, StreamID: s.id}, nil)
+}
+
+// Done is closed once the stream has ended, successfully or not.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the final status once the stream has ended.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finErr
+}
+
+func (s *Stream) push(data []byte, end bool) {
+	s.mu.Lock()
+	if len(data) > 0 {
+		s.recvq = append(s.recvq, data)
+	}
+	if end {
+		s.recvDone = true
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *Stream) grant(n int) {
+	s.mu.Lock()
+	s.sendWindow += n
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *Stream) finish(err error) {
+	s.mu.Lock()
+	if !s.finished {
+		s.finished = true
+		s.finErr = err
+		close(s.done)
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
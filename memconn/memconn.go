@@ -0,0 +1,275 @@
+// Package memconn provides an in-memory net.Listener, so that a server and its
+// clients can run in one process without opening a port:
+//
+//	ln := memconn.Listen(1 << 20)
+//	go srv.Serve(ln)
+//	cli, _ := client.Dial("memconn", client.WithDialer(ln.DialContext))
+package memconn
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the buffer size used when Listen is given a size of zero or less.
+const DefaultSize = 256 * 1024
+
+// Listener is a net.Listener whose connections are made by Dial.
+type Listener struct {
+	size      int
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen returns a listener whose connections buffer up to size bytes in each
+// direction. Like a socket buffer, a full buffer blocks writes until the other
+// side reads.
+func Listen(size int) *Listener {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Listener{
+		size:  size,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept waits for a connection made by Dial.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Connections already made stay open.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return addr{}
+}
+
+// Dial connects to the listener, waiting until the connection is accepted.
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "")
+}
+
+// DialContext is Dial with a context, usable as a client dialer. The address
+// is ignored.
+func (l *Listener) DialContext(ctx context.Context, _ string) (net.Conn, error) {
+	c2s, s2c := newPipe(l.size), newPipe(l.size)
+	client := newConn(s2c, c2s)
+	server := newConn(c2s, s2c)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type addr struct{}
+
+func (addr) Network() string { return "memconn" }
+func (addr) String() string  { return "memconn" }
+
+// pipe is one direction of a connection.
+type pipe struct {
+	mu      sync.Mutex
+	buf     []byte
+	size    int
+	rclosed bool          // the reading side is closed, writes fail
+	wclosed bool          // the writing side is closed, reads drain buf then see io.EOF
+	changed chan struct{} // closed and replaced whenever the above change
+}
+
+func newPipe(size int) *pipe {
+	return &pipe{size: size, changed: make(chan struct{})}
+}
+
+// broadcast wakes up blocked readers and writers. Called with p.mu held.
+func (p *pipe) broadcast() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+type conn struct {
+	r, w      *pipe
+	rd, wd    deadline
+	closeOnce sync.Once
+}
+
+func newConn(r, w *pipe) *conn {
+	return &conn{r: r, w: w, rd: makeDeadline(), wd: makeDeadline()}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	p := c.r
+	for {
+		if expired(c.rd.wait()) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.mu.Lock()
+		switch {
+		case p.rclosed:
+			p.mu.Unlock()
+			return 0, net.ErrClosed
+		case len(p.buf) > 0:
+			n := copy(b, p.buf)
+			p.buf = p.buf[:copy(p.buf, p.buf[n:])]
+			p.broadcast()
+			p.mu.Unlock()
+			return n, nil
+		case p.wclosed:
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-c.rd.wait():
+		}
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	p := c.w
+	written := 0
+	for len(b) > 0 {
+		if expired(c.wd.wait()) {
+			return written, os.ErrDeadlineExceeded
+		}
+		p.mu.Lock()
+		switch {
+		case p.wclosed:
+			p.mu.Unlock()
+			return written, net.ErrClosed
+		case p.rclosed:
+			p.mu.Unlock()
+			return written, io.ErrClosedPipe
+		case len(p.buf) < p.size:
+			n := min(p.size-len(p.buf), len(b))
+			p.buf = append(p.buf, b[:n]...)
+			b = b[n:]
+			written += n
+			p.broadcast()
+			p.mu.Unlock()
+			continue
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-c.wd.wait():
+		}
+	}
+	return written, nil
+}
+
+// Close closes both directions. The peer reads what was already written, then
+// io.EOF; its writes fail with io.ErrClosedPipe.
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.r.mu.Lock()
+		c.r.rclosed = true
+		c.r.buf = nil
+		c.r.broadcast()
+		c.r.mu.Unlock()
+
+		c.w.mu.Lock()
+		c.w.wclosed = true
+		c.w.broadcast()
+		c.w.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return addr{} }
+func (c *conn) RemoteAddr() net.Addr { return addr{} }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
+
+// deadline is a channel that is closed once the deadline passes.
+type deadline struct {
+	mu     *sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{mu: new(sync.Mutex), cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // the timer fired, wait for it to close cancel
+	}
+	d.timer = nil
+
+	closed := expired(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func expired(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package memconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T, size int) (client, server net.Conn) {
+	t.Helper()
+	ln := Listen(size)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, <-accepted
+}
+
+func TestReadWrite(t *testing.T) {
+	client, server := dial(t, 4)
+
+	// a write larger than the buffer completes as the other side reads
+	go func() {
+		if _, err := client.Write([]byte("hello, world")); err != nil {
+			t.Error(err)
+		}
+		client.Close()
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil || string(got) != "hello, world" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	if _, err := server.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("write to closed peer: %v", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("read after Close: %v", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	client, server := dial(t, 1)
+	defer client.Close()
+	defer server.Close()
+
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := server.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("read past deadline: %v", err)
+	}
+
+	client.Write([]byte("a")) // fills the buffer
+	client.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := client.Write([]byte("b")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("write past deadline: %v", err)
+	}
+
+	server.SetReadDeadline(time.Time{})
+	if n, err := server.Read(make([]byte, 2)); n != 1 || err != nil {
+		t.Fatalf("read after clearing deadline: %d, %v", n, err)
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	ln := Listen(0)
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept: %v", err)
+	}
+	if _, err := ln.Dial(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Dial: %v", err)
+	}
+}
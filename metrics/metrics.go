@@ -0,0 +1,176 @@
+// Package metrics provides the Prometheus collectors used by the server and
+// client when they are given a registry with WithMetrics.
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Status codes used as the "code" label of the request counters.
+const (
+	CodeOK              = "ok"
+	CodeError           = "error"             // the method returned an error
+	CodeNotFound        = "not_found"         // no such method
+	CodeTooManyRequests = "too_many_requests" // rejected by the pending request cap
+	CodeTooLarge        = "too_large"         // a message exceeded the size limit
+	CodeUnavailable     = "unavailable"       // the connection failed
+	CodeCircuitOpen     = "circuit_open"      // rejected by the client's breaker
+	CodeEncodeError     = "encode_error"      // the client could not encode the request
+	CodeCanceled        = "canceled"          // the caller gave up on the call
+)
+
+// MethodUnknown is the method label of calls to methods that are not
+// registered, so that arbitrary names sent by clients don't create new series.
+const MethodUnknown = "unknown"
+
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 10) // 64B .. 16MB
+
+// Metrics records calls and connections of one side, labelled by method. All
+// methods are no-ops on a nil *Metrics, so callers need not check whether
+// metrics are enabled.
+type Metrics struct {
+	requests  *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	inFlight  *prometheus.GaugeVec
+	reqBytes  *prometheus.HistogramVec
+	respBytes *prometheus.HistogramVec
+	conns     prometheus.Gauge
+	frameErrs prometheus.Counter
+}
+
+// NewServer registers the server collectors (xxrpc_server_*) with reg.
+func NewServer(reg prometheus.Registerer) (*Metrics, error) {
+	return newMetrics(reg, "server")
+}
+
+// NewClient registers the client collectors (xxrpc_client_*) with reg. Clients
+// sharing reg share the collectors.
+func NewClient(reg prometheus.Registerer) (*Metrics, error) {
+	return newMetrics(reg, "client")
+}
+
+func newMetrics(reg prometheus.Registerer, subsystem string) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "requests_total",
+			Help: "Calls completed, by method and status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "request_duration_seconds",
+			Help:    "Call latency, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "requests_in_flight",
+			Help: "Calls currently in progress, by method.",
+		}, []string{"method"}),
+		reqBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "request_size_bytes",
+			Help:    "Encoded size of call arguments, by method.",
+			Buckets: sizeBuckets,
+		}, []string{"method"}),
+		respBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "response_size_bytes",
+			Help:    "Encoded size of call results, by method.",
+			Buckets: sizeBuckets,
+		}, []string{"method"}),
+		conns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "connections_open",
+			Help: "Connections currently open.",
+		}),
+		frameErrs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "xxrpc", Subsystem: subsystem, Name: "frame_errors_total",
+			Help: "Connections that ended with a framing or read error.",
+		}),
+	}
+
+	var err error
+	register(reg, &m.requests, &err)
+	register(reg, &m.duration, &err)
+	register(reg, &m.inFlight, &err)
+	register(reg, &m.reqBytes, &err)
+	register(reg, &m.respBytes, &err)
+	register(reg, &m.conns, &err)
+	register(reg, &m.frameErrs, &err)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register adds *c to reg. If an identical collector is already registered,
+// e.g. by another client sharing the registry, *c is replaced by it.
+func register[C prometheus.Collector](reg prometheus.Registerer, c *C, errp *error) {
+	if *errp != nil {
+		return
+	}
+	err := reg.Register(*c)
+	var are prometheus.AlreadyRegisteredError
+	switch {
+	case err == nil:
+	case errors.As(err, &are):
+		existing, ok := are.ExistingCollector.(C)
+		if !ok {
+			*errp = err
+			return
+		}
+		*c = existing
+	default:
+		*errp = err
+	}
+}
+
+// Start records that a call to method has begun.
+func (m *Metrics) Start(method string) {
+	if m == nil {
+		return
+	}
+	m.inFlight.WithLabelValues(method).Inc()
+}
+
+// Done records a finished call that started at start. Negative sizes are not
+// observed, e.g. for streams or one-way calls without a response.
+func (m *Metrics) Done(method, code string, start time.Time, reqBytes, respBytes int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.WithLabelValues(method).Dec()
+	m.requests.WithLabelValues(method, code).Inc()
+	m.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if reqBytes >= 0 {
+		m.reqBytes.WithLabelValues(method).Observe(float64(reqBytes))
+	}
+	if respBytes >= 0 {
+		m.respBytes.WithLabelValues(method).Observe(float64(respBytes))
+	}
+}
+
+// Rejected records a call to method that was refused before it started, e.g.
+// by the pending request cap.
+func (m *Metrics) Rejected(method, code string) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(method, code).Inc()
+}
+
+func (m *Metrics) ConnOpened() {
+	if m != nil {
+		m.conns.Inc()
+	}
+}
+
+func (m *Metrics) ConnClosed() {
+	if m != nil {
+		m.conns.Dec()
+	}
+}
+
+func (m *Metrics) FrameError() {
+	if m != nil {
+		m.frameErrs.Inc()
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m1, err := NewClient(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := NewClient(reg)
+	if err != nil {
+		t.Fatalf("second client: %v", err)
+	}
+
+	for _, m := range []*Metrics{m1, m2} {
+		m.Start("Echo.Say")
+		m.Done("Echo.Say", CodeOK, time.Now(), 10, 20)
+	}
+	m1.Start("Echo.Say")
+	m1.Done("Echo.Say", CodeError, time.Now(), 10, -1)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "xxrpc_client_requests_total" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "code" {
+					counts[l.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if counts[CodeOK] != 2 || counts[CodeError] != 1 {
+		t.Fatalf("requests_total = %v", counts)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Start("Echo.Say")
+	m.Done("Echo.Say", CodeOK, time.Now(), 1, 1)
+	m.Rejected("Echo.Say", CodeTooManyRequests)
+	m.ConnOpened()
+	m.FrameError()
+}
@@ -0,0 +1,226 @@
+package naming
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/discovery"
+)
+
+var _ discovery.Registrar = (*Registrar)(nil)
+var _ discovery.Resolver = (*Resolver)(nil)
+
+var (
+	errClosed     = errors.New("naming: client closed")
+	errWatchEnded = errors.New("naming: watch ended by the server")
+)
+
+// conn is a connection to the naming server that is redialed on the next call
+// after it fails, e.g. because the naming server restarted.
+type conn struct {
+	addr string
+	opts []client.Option
+
+	mu     sync.Mutex
+	cli    *client.Client
+	closed bool
+}
+
+func dial(addr string, opts []client.Option) (*conn, error) {
+	c := &conn{addr: addr, opts: opts}
+	if _, err := c.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) get() (*client.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errClosed
+	}
+	if c.cli != nil && c.cli.Healthy() {
+		return c.cli, nil
+	}
+	if c.cli != nil {
+		c.cli.Close()
+		c.cli = nil
+	}
+	cli, err := client.Dial(c.addr, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.cli = cli
+	return cli, nil
+}
+
+// call invokes method and decodes its result into out.
+func (c *conn) call(method string, req, out any) error {
+	cli, err := c.get()
+	if err != nil {
+		return err
+	}
+	resp, err := cli.Call(ServiceName+"."+method, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if resp.Data == nil {
+		return nil
+	}
+	return cli.Codec().Unmarshal(*resp.Data, out)
+}
+
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.cli == nil {
+		return nil
+	}
+	return c.cli.Close()
+}
+
+// Registrar registers instances with a naming server.
+type Registrar struct {
+	conn *conn
+}
+
+func NewRegistrar(addr string, opts ...client.Option) (*Registrar, error) {
+	c, err := dial(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Registrar{conn: c}, nil
+}
+
+func (r *Registrar) Register(instance discovery.Instance, ttl time.Duration) error {
+	var resp RegisterResp
+	return r.conn.call("Register", &RegisterReq{Instance: instance, TTLMs: ttl.Milliseconds()}, &resp)
+}
+
+func (r *Registrar) Deregister(instance discovery.Instance) error {
+	var resp DeregisterResp
+	return r.conn.call("Deregister", &DeregisterReq{Service: instance.Service, Addr: instance.Addr}, &resp)
+}
+
+func (r *Registrar) Close() error {
+	return r.conn.close()
+}
+
+// Resolver resolves services through a naming server. Its watchers share its
+// connection, so they stop working once it is closed.
+type Resolver struct {
+	conn *conn
+}
+
+func NewResolver(addr string, opts ...client.Option) (*Resolver, error) {
+	c, err := dial(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{conn: c}, nil
+}
+
+func (r *Resolver) Resolve(service string) ([]discovery.Instance, error) {
+	var resp InstancesResp
+	if err := r.conn.call("Resolve", &ResolveReq{Service: service}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Instances) == 0 {
+		return nil, discovery.ErrServiceNotFound
+	}
+	return resp.Instances, nil
+}
+
+func (r *Resolver) Watch(service string) (discovery.Watcher, error) {
+	return &watcher{conn: r.conn, service: service}, nil
+}
+
+func (r *Resolver) Close() error {
+	return r.conn.close()
+}
+
+// watcher follows a Watch stream, reopening it on the next call to Next after
+// it broke.
+type watcher struct {
+	conn    *conn
+	service string
+
+	mu     sync.Mutex
+	stream *client.BidiStream[WatchReq, InstancesResp]
+	closed bool
+}
+
+func (w *watcher) Next() ([]discovery.Instance, error) {
+	s, err := w.open()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Recv()
+	if err != nil {
+		w.mu.Lock()
+		if w.stream == s {
+			w.stream = nil
+		}
+		stopped := w.closed
+		w.mu.Unlock()
+		s.Close()
+		if stopped {
+			return nil, discovery.ErrWatcherStopped
+		}
+		if err == io.EOF {
+			err = errWatchEnded
+		}
+		return nil, err
+	}
+	return resp.Instances, nil
+}
+
+// open returns the current stream or opens a new one. A new stream starts
+// with the current instance set, since the naming server may have restarted
+// and lost the revisions seen so far.
+func (w *watcher) open() (*client.BidiStream[WatchReq, InstancesResp], error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, discovery.ErrWatcherStopped
+	}
+	if w.stream != nil {
+		return w.stream, nil
+	}
+	cli, err := w.conn.get()
+	if err != nil {
+		return nil, err
+	}
+	s, err := client.OpenStream[WatchReq, InstancesResp](cli, ServiceName+".Watch")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Send(&WatchReq{Service: w.service}); err != nil {
+		s.Close()
+		return nil, err
+	}
+	w.stream = s
+	return s, nil
+}
+
+// Stop aborts a pending Next. The connection stays open for the resolver.
+func (w *watcher) Stop() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.stream != nil {
+		w.stream.Close()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package naming
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/discovery"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+	"xxrpc/server"
+)
+
+// restartable runs a naming server on a memconn listener that can be replaced.
+type restartable struct {
+	t   *testing.T
+	mu  sync.Mutex
+	srv *server.Server
+	ln  *memconn.Listener
+}
+
+func (r *restartable) start() {
+	svc, err := NewService()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	s := server.NewServer("naming", registry.NewRegister())
+	s.Register(svc)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+
+	r.mu.Lock()
+	r.srv, r.ln = s, ln
+	r.mu.Unlock()
+}
+
+// stop shuts the server down and drops its connections.
+func (r *restartable) stop() {
+	r.mu.Lock()
+	s := r.srv
+	r.mu.Unlock()
+	s.Stop()
+	for _, p := range s.Peers() {
+		p.Close()
+	}
+}
+
+func (r *restartable) dial(ctx context.Context, addr string) (net.Conn, error) {
+	r.mu.Lock()
+	ln := r.ln
+	r.mu.Unlock()
+	return ln.DialContext(ctx, addr)
+}
+
+func TestClientRedial(t *testing.T) {
+	r := &restartable{t: t}
+	r.start()
+	defer func() { r.stop() }()
+
+	opt := client.WithDialer(r.dial)
+	reg, err := NewRegistrar("naming", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	res, err := NewResolver("naming", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	w, _ := res.Watch("echo")
+	defer w.Stop()
+	if _, err := w.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.stop()
+	r.start()
+
+	ins := discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}
+	var regErr error
+	for i := 0; i < 2; i++ {
+		// the first call may still see the dropped connection
+		if regErr = reg.Register(ins, time.Minute); regErr == nil {
+			break
+		}
+	}
+	if regErr != nil {
+		t.Fatalf("Register after restart: %v", regErr)
+	}
+
+	var instances []discovery.Instance
+	for i := 0; i < 2 && len(instances) == 0; i++ {
+		instances, err = w.Next()
+	}
+	if err != nil || len(instances) != 1 || instances[0].Addr != ins.Addr {
+		t.Fatalf("watch after restart: %v, %v", instances, err)
+	}
+}
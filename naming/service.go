@@ -0,0 +1,145 @@
+package naming
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"xxrpc/discovery"
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+)
+
+const ServiceName = "Naming"
+
+type RegisterReq struct {
+	Instance discovery.Instance
+	TTLMs    int64 // lease TTL in milliseconds, 0 means no expiry
+}
+
+type RegisterResp struct {
+	Revision uint64
+}
+
+type DeregisterReq struct {
+	Service string
+	Addr    string
+}
+
+type DeregisterResp struct {
+	Revision uint64
+}
+
+type ResolveReq struct {
+	Service string
+}
+
+type WatchReq struct {
+	Service  string
+	Revision uint64 // only push instance sets newer than this, 0 pushes the current set first
+}
+
+type InstancesResp struct {
+	Instances []discovery.Instance
+	Revision  uint64
+}
+
+type Option interface {
+	Apply(*Service)
+}
+
+type optionFunc func(*Service)
+
+func (f optionFunc) Apply(s *Service) {
+	f(s)
+}
+
+// WithPersistence snapshots all leases to path on every change and restores them on start.
+func WithPersistence(path string) Option {
+	return optionFunc(func(s *Service) {
+		s.path = path
+	})
+}
+
+// WithLogger sets the logger used to report persistence failures.
+func WithLogger(logger *zap.Logger) Option {
+	return optionFunc(func(s *Service) {
+		s.logger = logger
+	})
+}
+
+// Service is a naming server that is itself an xxrpc service: servers register
+// their instances with leases, clients resolve them and Watch streams every change to them.
+type Service struct {
+	path   string
+	logger *zap.Logger
+	store  *store
+}
+
+func NewService(opts ...Option) (*Service, error) {
+	s := &Service{logger: zap.NewNop()}
+	for _, opt := range opts {
+		opt.Apply(s)
+	}
+	st, err := newStore(s.path, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	s.store = st
+	return s, nil
+}
+
+func (*Service) Name() string {
+	return ServiceName
+}
+
+func (s *Service) Register(r *registry.Registry, c codec.Codec) {
+	r.ServiceMethods[s.Name()+".Register"] = registry.NewUnaryMethod(c, s.RegisterInstance)
+	r.ServiceMethods[s.Name()+".Deregister"] = registry.NewUnaryMethod(c, s.DeregisterInstance)
+	r.ServiceMethods[s.Name()+".Resolve"] = registry.NewUnaryMethod(c, s.Resolve)
+	r.ServiceMethods[s.Name()+".Watch"] = registry.NewStreamMethod(s.Watch)
+}
+
+func (s *Service) RegisterInstance(req *RegisterReq) (*RegisterResp, error) {
+	if req.Instance.Service == "" || req.Instance.Addr == "" {
+		return nil, errors.New("naming: instance needs a service and an address")
+	}
+	rev := s.store.register(req.Instance, time.Duration(req.TTLMs)*time.Millisecond)
+	return &RegisterResp{Revision: rev}, nil
+}
+
+func (s *Service) DeregisterInstance(req *DeregisterReq) (*DeregisterResp, error) {
+	return &DeregisterResp{Revision: s.store.deregister(req.Service, req.Addr)}, nil
+}
+
+func (s *Service) Resolve(req *ResolveReq) (*InstancesResp, error) {
+	instances, rev, _ := s.store.snapshot(req.Service)
+	return &InstancesResp{Instances: instances, Revision: rev}, nil
+}
+
+// Watch receives one WatchReq, then pushes the instances of req.Service each
+// time they change until the stream ends. With Revision 0 the current set is
+// pushed right away.
+func (s *Service) Watch(st registry.ServerStream[WatchReq, InstancesResp]) error {
+	req, err := st.Recv()
+	if err != nil {
+		return err
+	}
+
+	last, first := req.Revision, req.Revision == 0
+	for {
+		instances, rev, changed := s.store.snapshot(req.Service)
+		if first || rev > last {
+			if err := st.Send(&InstancesResp{Instances: instances, Revision: rev}); err != nil {
+				return err
+			}
+			last, first = rev, false
+		}
+		select {
+		case <-changed:
+		case <-st.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package naming
+
+import (
+	"testing"
+
+	"xxrpc/client"
+	"xxrpc/discovery"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+	"xxrpc/server"
+)
+
+func TestServiceOverRPC(t *testing.T) {
+	svc, err := NewService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewServer("naming", registry.NewRegister())
+	s.Register(svc)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	cli, err := client.Dial("naming", client.WithDialer(ln.DialContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	st, err := client.OpenStream[WatchReq, InstancesResp](cli, ServiceName+".Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.Send(&WatchReq{Service: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	next := func(want int) uint64 {
+		t.Helper()
+		resp, err := st.Recv()
+		if err != nil || len(resp.Instances) != want {
+			t.Fatalf("watch pushed %+v, %v, want %d instances", resp, err, want)
+		}
+		return resp.Revision
+	}
+	next(0)
+
+	call := func(method string, req, out any) {
+		t.Helper()
+		resp, err := cli.Call(ServiceName+"."+method, req)
+		if err != nil || resp.Error != "" {
+			t.Fatalf("%s: %v %s", method, err, resp.Error)
+		}
+		if err := cli.Codec().Unmarshal(*resp.Data, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reg RegisterResp
+	call("Register", &RegisterReq{Instance: discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}}, &reg)
+	if rev := next(1); rev != reg.Revision {
+		t.Fatalf("watch pushed revision %d, Register returned %d", rev, reg.Revision)
+	}
+	call("Register", &RegisterReq{Instance: discovery.Instance{Service: "echo", Addr: "127.0.0.1:8002"}, TTLMs: 20}, &reg)
+	next(2)
+
+	var res InstancesResp
+	call("Resolve", &ResolveReq{Service: "echo"}, &res)
+	if len(res.Instances) != 2 || res.Revision != reg.Revision {
+		t.Fatalf("Resolve = %+v, want 2 instances at revision %d", res, reg.Revision)
+	}
+
+	// the lease of 8002 expires without renewals
+	next(1)
+
+	var dereg DeregisterResp
+	call("Deregister", &DeregisterReq{Service: "echo", Addr: "127.0.0.1:8001"}, &dereg)
+	if rev := next(0); rev != dereg.Revision {
+		t.Fatalf("watch pushed revision %d, Deregister returned %d", rev, dereg.Revision)
+	}
+
+	// a watch from a known revision only receives newer sets
+	st2, err := client.OpenStream[WatchReq, InstancesResp](cli, ServiceName+".Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st2.Close()
+	st2.Send(&WatchReq{Service: "echo", Revision: dereg.Revision})
+	call("Register", &RegisterReq{Instance: discovery.Instance{Service: "echo", Addr: "127.0.0.1:8003"}}, &reg)
+	resp, err := st2.Recv()
+	if err != nil || resp.Revision != reg.Revision {
+		t.Fatalf("watch from revision %d pushed %+v, %v", dereg.Revision, resp, err)
+	}
+}
@@ -0,0 +1,285 @@
+package naming
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"xxrpc/discovery"
+)
+
+type lease struct {
+	instance discovery.Instance
+	ttl      time.Duration // zero means no expiry
+	timer    *time.Timer
+	gen      uint64
+}
+
+type serviceEntry struct {
+	leases   map[string]*lease // addr -> lease
+	revision uint64
+	changed  chan struct{} // closed and replaced on every change
+}
+
+// store holds the leases of all services. Every change bumps a global
+// revision, which watchers use to ask for anything newer than what they saw.
+type store struct {
+	mu       sync.Mutex
+	revision uint64
+	services map[string]*serviceEntry
+	added    chan struct{} // closed and replaced when a service is first registered
+	dirty    bool          // a lease TTL changed without a revision bump
+
+	path    string // snapshot file, empty disables persistence
+	logger  *zap.Logger
+	writeMu sync.Mutex // serializes snapshot writes, which happen outside mu
+	written uint64     // revision of the last snapshot written, guarded by writeMu
+}
+
+func newStore(path string, logger *zap.Logger) (*store, error) {
+	st := &store{
+		services: make(map[string]*serviceEntry),
+		added:    make(chan struct{}),
+		path:     path,
+		logger:   logger,
+	}
+	if path != "" {
+		if err := st.load(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (st *store) entry(service string) *serviceEntry {
+	e, ok := st.services[service]
+	if !ok {
+		e = &serviceEntry{leases: make(map[string]*lease), changed: make(chan struct{})}
+		st.services[service] = e
+		close(st.added)
+		st.added = make(chan struct{})
+	}
+	return e
+}
+
+func (st *store) register(ins discovery.Instance, ttl time.Duration) uint64 {
+	defer st.persist()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e := st.entry(ins.Service)
+	l, ok := e.leases[ins.Addr]
+	if !ok {
+		l = &lease{}
+		e.leases[ins.Addr] = l
+	}
+	changed := !ok || !sameInstance(l.instance, ins)
+	if ok && l.ttl != ttl {
+		st.dirty = true
+	}
+	l.instance = ins
+	st.arm(l, ttl)
+
+	if changed {
+		st.bump(e)
+	}
+	// plain renewals write nothing: load restarts every lease with its full TTL
+	return st.revision
+}
+
+func (st *store) deregister(service, addr string) uint64 {
+	defer st.persist()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.services[service]
+	if !ok {
+		return st.revision
+	}
+	l, ok := e.leases[addr]
+	if !ok {
+		return st.revision
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	delete(e.leases, addr)
+	st.bump(e)
+	return st.revision
+}
+
+// arm (re)starts the expiry timer of l. st.mu must be held.
+func (st *store) arm(l *lease, ttl time.Duration) {
+	l.gen++
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.ttl = ttl
+	if ttl <= 0 {
+		return
+	}
+	gen, service, addr := l.gen, l.instance.Service, l.instance.Addr
+	l.timer = time.AfterFunc(ttl, func() { st.expire(service, addr, gen) })
+}
+
+func (st *store) expire(service, addr string, gen uint64) {
+	defer st.persist()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.services[service]
+	if !ok {
+		return
+	}
+	if l, ok := e.leases[addr]; !ok || l.gen != gen {
+		return
+	}
+	delete(e.leases, addr)
+	st.bump(e)
+}
+
+// bump records a change of e and wakes its watchers. st.mu must be held.
+func (st *store) bump(e *serviceEntry) {
+	st.revision++
+	e.revision = st.revision
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// snapshot returns the instances of service, its revision and a channel that is
+// closed on the next change. An unknown service has revision 0.
+func (st *store) snapshot(service string) ([]discovery.Instance, uint64, <-chan struct{}) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.services[service]
+	if !ok {
+		// reads don't create entries, so wait for any service to be added instead
+		return nil, 0, st.added
+	}
+	instances := make([]discovery.Instance, 0, len(e.leases))
+	for _, l := range e.leases {
+		instances = append(instances, l.instance)
+	}
+	return instances, e.revision, e.changed
+}
+
+type snapshotFile struct {
+	Revision uint64
+	Leases   []snapshotLease
+}
+
+type snapshotLease struct {
+	Instance discovery.Instance
+	TTL      time.Duration `json:",omitempty"`
+}
+
+// persist writes all leases to st.path if they changed since the last write.
+// It is called after st.mu is released so that file I/O never blocks the store.
+func (st *store) persist() {
+	if st.path == "" {
+		return
+	}
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	st.mu.Lock()
+	if st.revision == st.written && !st.dirty {
+		st.mu.Unlock()
+		return
+	}
+	snap := snapshotFile{Revision: st.revision}
+	for _, e := range st.services {
+		for _, l := range e.leases {
+			snap.Leases = append(snap.Leases, snapshotLease{Instance: l.instance, TTL: l.ttl})
+		}
+	}
+	st.dirty = false
+	st.mu.Unlock()
+
+	if err := st.write(snap); err != nil {
+		st.mu.Lock()
+		st.dirty = true
+		st.mu.Unlock()
+		st.logger.Error("failed to persist naming data", zap.String("path", st.path), zap.Error(err))
+		return
+	}
+	st.written = snap.Revision
+}
+
+func (st *store) write(snap snapshotFile) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so a crash never leaves a torn snapshot
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), ".naming-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), st.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// load restores leases from st.path. Renewals are not persisted, so every
+// lease restarts with its full TTL and instances that died while the server
+// was down expire one TTL after the restart.
+func (st *store) load() error {
+	data, err := os.ReadFile(st.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.revision = snap.Revision
+	st.written = snap.Revision
+	for _, sl := range snap.Leases {
+		e := st.entry(sl.Instance.Service)
+		l := &lease{instance: sl.Instance}
+		e.leases[sl.Instance.Addr] = l
+		e.revision = st.revision
+		st.arm(l, sl.TTL)
+	}
+	return nil
+}
+
+func sameInstance(a, b discovery.Instance) bool {
+	if a.Service != b.Service || a.Addr != b.Addr || a.Weight != b.Weight || len(a.Tags) != len(b.Tags) || len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	for i := range a.Tags {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+	for k, v := range a.Metadata {
+		if bv, ok := b.Metadata[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package naming
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"xxrpc/discovery"
+)
+
+func TestStoreWatch(t *testing.T) {
+	st, _ := newStore("", zap.NewNop())
+	_, rev, added := st.snapshot("echo")
+
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}, 0)
+	select {
+	case <-added:
+	default:
+		t.Fatal("registering a new service did not signal")
+	}
+	instances, got, changed := st.snapshot("echo")
+	if len(instances) != 1 || got <= rev {
+		t.Fatalf("snapshot = %v at revision %d, want one instance after revision %d", instances, got, rev)
+	}
+
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8002"}, 0)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("register did not signal the service's watchers")
+	}
+
+	// a renewal with identical contents is not a change
+	before := st.revision
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}, 0)
+	if st.revision != before {
+		t.Fatalf("renewal bumped revision from %d to %d", before, st.revision)
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.json")
+
+	st, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001", Weight: 2}, time.Hour)
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8002"}, 0)
+	st.deregister("echo", "127.0.0.1:8002")
+	rev := st.revision
+
+	restored, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, gotRev, _ := restored.snapshot("echo")
+	if len(instances) != 1 || instances[0].Weight != 2 || gotRev != rev {
+		t.Fatalf("restored %v at revision %d, want one instance at revision %d", instances, gotRev, rev)
+	}
+}
+
+func TestStoreReadsDontCreateEntries(t *testing.T) {
+	st, _ := newStore("", zap.NewNop())
+	st.snapshot("missing")
+	st.deregister("missing", "127.0.0.1:8001")
+	if len(st.services) != 0 {
+		t.Fatalf("reads created entries: %v", st.services)
+	}
+}
+
+// crash stops the expiry timers of st as if its process had died.
+func crash(st *store) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.services {
+		for _, l := range e.leases {
+			if l.timer != nil {
+				l.timer.Stop()
+			}
+		}
+	}
+}
+
+func waitGone(t *testing.T, st *store, service string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if instances, _, _ := st.snapshot(service); len(instances) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lease of %s did not expire", service)
+		}
+	}
+}
+
+func TestStoreRestartAfterRenew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.json")
+	ins := discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}
+	const ttl = 100 * time.Millisecond
+
+	st, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.register(ins, ttl)
+	time.Sleep(ttl / 2)
+	st.register(ins, ttl)
+	crash(st)
+	// restart after the deadline of the first registration, but within the renewed one
+	time.Sleep(ttl / 2)
+
+	restored, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instances, _, _ := restored.snapshot("echo"); len(instances) != 1 {
+		t.Fatalf("restored %v, want the renewed instance", instances)
+	}
+	// without further renewals the lease still expires
+	waitGone(t, restored, "echo")
+}
+
+func TestStoreRestartKeepsRenewedTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.json")
+	ins := discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}
+
+	st, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.register(ins, time.Hour)
+	// a renewal that shortens the TTL is written even though nothing else changed
+	st.register(ins, 20*time.Millisecond)
+	crash(st)
+
+	restored, err := newStore(path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitGone(t, restored, "echo")
+}
+
+func TestStoreTTLExpiry(t *testing.T) {
+	st, _ := newStore("", zap.NewNop())
+	_, _, added := st.snapshot("echo")
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8001"}, 20*time.Millisecond)
+	st.register(discovery.Instance{Service: "echo", Addr: "127.0.0.1:8002"}, 0)
+	<-added
+	_, rev, changed := st.snapshot("echo")
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expiry did not signal the service's watchers")
+	}
+	instances, got, _ := st.snapshot("echo")
+	if len(instances) != 1 || instances[0].Addr != "127.0.0.1:8002" || got <= rev {
+		t.Fatalf("after expiry: %v at revision %d, want only the lease without TTL after revision %d", instances, got, rev)
+	}
+}
@@ -21,11 +21,13 @@ var ErrFrameTooLarge = errors.New("frame too large")
 // FrameConn wraps net.Conn and provides framed Read/Write with buffer reuse.
 // IMPORTANT: Frame slices returned by ReadFrame reference internal buffer.
 // Caller MUST NOT hold onto the slice after next ReadFrame call (or Close()).
+// Writes are safe for concurrent use; reads must come from a single goroutine.
 type FrameConn struct {
 	conn  net.Conn
-	buf   *[]byte // from frameBufPool
-	start int     // start index of unread data in buf
-	end   int     // end index (exclusive) of data in buf
+	wmu   sync.Mutex // serializes writes so frames never interleave
+	buf   *[]byte    // from frameBufPool
+	start int        // start index of unread data in buf
+	end   int        // end index (exclusive) of data in buf
 	// optional max frame size to protect memory blowup
 	MaxFrameSize int
 }
@@ -43,164 +45,95 @@ func NewFrameConn(c net.Conn) *FrameConn {
 }
 
 // Close returns the buffer to pool and closes underlying conn.
+// It must not be called concurrently with ReadFrame; use Shutdown to unblock a reader.
 func (fc *FrameConn) Close() error {
 	// return buffer
 	if fc.buf != nil {
 		frameBufPool.Put(fc.buf)
 		fc.buf = nil
 	}
-	if fc.conn != nil {
-		err := fc.conn.Close()
-		fc.conn = nil
-		return err
-	}
-	return nil
+	return fc.conn.Close()
+}
+
+// Shutdown closes the underlying conn without releasing the read buffer, so a
+// ReadFrame blocked in another goroutine fails and that goroutine can call Close.
+func (fc *FrameConn) Shutdown() error {
+	return fc.conn.Close()
 }
 
-func (fc *FrameConn) ensureAvailable(n int) (bool, error) {
+func (fc *FrameConn) RemoteAddr() net.Addr {
+	return fc.conn.RemoteAddr()
+}
+
+func (fc *FrameConn) LocalAddr() net.Addr {
+	return fc.conn.LocalAddr()
+}
+
+// fill reads from conn until at least n unread bytes are buffered.
+// n must not exceed the buffer size.
+func (fc *FrameConn) fill(n int) error {
 	if fc.end-fc.start >= n {
-		return true, nil
+		return nil
 	}
 
-	// 如果 start 和 end 非零，并且未满，则将剩余的字节复制到缓冲区的开头
-	if fc.start > 0 && fc.start != fc.end {
-		copy((*fc.buf)[:fc.end-fc.start], (*fc.buf)[fc.start:fc.end])
-		fc.end = fc.end - fc.start
-		fc.start = 0
-	} else if fc.start == fc.end {
+	// 剩余空间不够时，将未读的字节移动到缓冲区开头
+	if fc.start+n > len(*fc.buf) || fc.start == fc.end {
+		copy(*fc.buf, (*fc.buf)[fc.start:fc.end])
+		fc.end -= fc.start
 		fc.start = 0
-		fc.end = 0
-	}
-
-	// 如果缓冲区容量不够，进行一次读取操作
-	capacity := cap(*fc.buf)
-	if fc.end+1 > capacity {
-		if n > capacity {
-			return false, nil
-		}
 	}
 
-	readInto := (*fc.buf)[fc.end:capacity]
-	nr, err := fc.conn.Read(readInto)
-	if nr > 0 {
+	for fc.end-fc.start < n {
+		nr, err := fc.conn.Read((*fc.buf)[fc.end:])
 		fc.end += nr
-	}
-	if err != nil {
-		if err == io.EOF && fc.end-fc.start >= n {
-			return true, nil
+		if err != nil {
+			if fc.end-fc.start >= n {
+				return nil
+			}
+			if err == io.EOF && fc.end > fc.start {
+				return io.ErrUnexpectedEOF
+			}
+			return err
 		}
-		return false, err
 	}
-
-	return fc.end-fc.start >= n, nil
+	return nil
 }
 
 // ReadFrame returns a single full frame (payload bytes, without length prefix).
 // The returned slice references an internal buffer; do NOT retain it.
 func (fc *FrameConn) ReadFrame() ([]byte, error) {
-	// First ensure 4 bytes for length
-	ok, err := fc.ensureAvailable(4)
-	if err != nil {
+	if err := fc.fill(4); err != nil {
 		return nil, err
 	}
-	if !ok {
-		// Not enough data after single read: try blocking read with io.ReadFull as fallback
-		var lenBuf [4]byte
-		// If there's partial, copy what we have then read remaining
-		avail := fc.end - fc.start
-		if avail > 0 {
-			copy(lenBuf[:], (*fc.buf)[fc.start:fc.end])
-			//want := 4 - avail
-			if _, err := io.ReadFull(fc.conn, lenBuf[avail:]); err != nil {
-				return nil, err
-			}
-			// update buf state: we consumed these avail bytes
-			fc.start += avail
-		} else {
-			if _, err := io.ReadFull(fc.conn, lenBuf[:]); err != nil {
-				return nil, err
-			}
-		}
-		length := binary.BigEndian.Uint32(lenBuf[:])
-		if int(length) > fc.MaxFrameSize {
-			return nil, ErrFrameTooLarge
-		}
-		// now read payload
-		// try to use buffer if fits
-		capacity := cap(*fc.buf)
-		if int(length) <= capacity {
-			// ensure we have length bytes in buffer
-			// read into buffer directly
-			// reset start/end
+	frameLen := int(binary.BigEndian.Uint32((*fc.buf)[fc.start:]))
+	if frameLen > fc.MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	total := 4 + frameLen
+	if total > len(*fc.buf) {
+		// payload too big for the buffer: take what is buffered, read the rest into a fresh slice
+		fc.start += 4
+		out := make([]byte, frameLen)
+		n := copy(out, (*fc.buf)[fc.start:fc.end])
+		fc.start += n
+		if fc.start == fc.end {
 			fc.start = 0
 			fc.end = 0
-			if _, err := io.ReadFull(fc.conn, (*fc.buf)[:length]); err != nil {
-				return nil, err
-			}
-			fc.end = int(length)
-			return (*fc.buf)[:length], nil
 		}
-		// else allocate exact slice (not pooled)
-		out := make([]byte, length)
-		if _, err := io.ReadFull(fc.conn, out); err != nil {
+		if _, err := io.ReadFull(fc.conn, out[n:]); err != nil {
 			return nil, err
 		}
 		return out, nil
 	}
 
-	// We have at least 4 bytes in buffer
-	lenBytes := (*fc.buf)[fc.start : fc.start+4]
-	frameLen := binary.BigEndian.Uint32(lenBytes)
-	if int(frameLen) > fc.MaxFrameSize {
-		return nil, ErrFrameTooLarge
-	}
-
-	// Ensure payload bytes are available in buffer
-	totalNeed := 4 + int(frameLen)
-	if fc.end-fc.start < totalNeed {
-		// try reading more once
-		ok2, err := fc.ensureAvailable(totalNeed)
-		if err != nil {
-			return nil, err
-		}
-		if !ok2 {
-			// fallback to io.ReadFull to fill remaining bytes
-			//needed := totalNeed - (fc.end - fc.start)
-			// if payload too big to fit in buffer, read the remainder into a new slice:
-			if int(frameLen) > cap(*fc.buf) {
-				// copy what we have for the header (we'll discard), then read remainder into fresh slice
-				// advance start to consume header
-				fc.start += 4
-				out := make([]byte, frameLen)
-				// first copy any partial payload present
-				present := fc.end - fc.start
-				if present > 0 {
-					copy(out[:present], (*fc.buf)[fc.start:fc.end])
-				}
-				if _, err := io.ReadFull(fc.conn, out[present:]); err != nil {
-					return nil, err
-				}
-				// after consuming, reset buffer
-				fc.start = 0
-				fc.end = 0
-				return out, nil
-			}
-			// otherwise try to fill buffer
-			if _, err := io.ReadFull(fc.conn, (*fc.buf)[fc.end:fc.start+totalNeed]); err != nil {
-				return nil, err
-			}
-			fc.end = fc.start + totalNeed
-		}
+	if err := fc.fill(total); err != nil {
+		return nil, err
 	}
+	payload := (*fc.buf)[fc.start+4 : fc.start+total]
 
-	// Now we definitely have a full frame in buffer
-	payloadStart := fc.start + 4
-	payloadEnd := payloadStart + int(frameLen)
-	payload := (*fc.buf)[payloadStart:payloadEnd]
-
-	// advance start
-	fc.start = payloadEnd
-	// if buffer consumed entirely, reset indices
+	// advance start; if buffer consumed entirely, reset indices
+	fc.start += total
 	if fc.start == fc.end {
 		fc.start = 0
 		fc.end = 0
@@ -214,6 +147,35 @@ func (fc *FrameConn) WriteFrame(payload []byte) error {
 	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
 
 	buf := net.Buffers{lenBuf[:], payload}
+	fc.wmu.Lock()
+	_, err := buf.WriteTo(fc.conn)
+	fc.wmu.Unlock()
+	return err
+}
+
+// ReadMessage reads one frame and splits it into header and body.
+// The body references the internal buffer like ReadFrame.
+func (fc *FrameConn) ReadMessage() (Header, []byte, error) {
+	payload, err := fc.ReadFrame()
+	if err != nil {
+		return Header{}, nil, err
+	}
+	h, err := decodeHeader(payload)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	return h, payload[HeaderSize:], nil
+}
+
+// WriteMessage writes h and body as a single frame.
+func (fc *FrameConn) WriteMessage(h Header, body []byte) error {
+	var hdr [4 + HeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(HeaderSize+len(body)))
+	h.encode(hdr[4:])
+
+	buf := net.Buffers{hdr[:], body}
+	fc.wmu.Lock()
 	_, err := buf.WriteTo(fc.conn)
+	fc.wmu.Unlock()
 	return err
 }
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// Frames around the 64KB pooled buffer size take different read paths.
+func TestFrameConnSizes(t *testing.T) {
+	c1, c2 := net.Pipe()
+	w := NewFrameConn(c1)
+	r := NewFrameConn(c2)
+	defer w.Close()
+	defer r.Close()
+
+	sizes := []int{0, 10, 3, 64*1024 - 10, 64*1024 - 4, 64 * 1024, 70000, 150000, 1}
+	for i := 0; i < 3; i++ {
+		sizes = append(sizes, sizes...)
+	}
+
+	go func() {
+		for i, n := range sizes {
+			if err := w.WriteMessage(Header{Type: FrameData, StreamID: uint32(i)}, bytes.Repeat([]byte{byte(i)}, n)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i, n := range sizes {
+		h, body, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if h.Type != FrameData || h.StreamID != uint32(i) || len(body) != n {
+			t.Fatalf("frame %d: got %+v with %d bytes, want %d bytes", i, h, len(body), n)
+		}
+		if n > 0 && (body[0] != byte(i) || body[n-1] != byte(i)) {
+			t.Fatalf("frame %d: corrupted body", i)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FrameType identifies what a frame carries. Every frame payload starts with a
+// HeaderSize header followed by the body.
+type FrameType uint8
+
+const (
+	FrameRequest      FrameType = iota + 1 // body: encoded Request, opens a unary call or a stream
+	FrameResponse                          // body: encoded Response, ends a unary call or a stream
+	FrameData                              // body: one encoded stream message
+	FrameReset                             // no body, aborts a stream
+	FrameWindowUpdate                      // body: 4 byte send credit granted to the peer
+	FramePing                              // body: 8 opaque bytes, answered with a pong
+	FramePong                              // body: the bytes of the ping it answers
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameRequest:
+		return "request"
+	case FrameResponse:
+		return "response"
+	case FrameData:
+		return "data"
+	case FrameReset:
+		return "reset"
+	case FrameWindowUpdate:
+		return "window_update"
+	case FramePing:
+		return "ping"
+	case FramePong:
+		return "pong"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// FlagStream marks a FrameRequest that opens a stream instead of a unary call.
+	FlagStream uint8 = 1 << iota
+	// FlagEndStream marks the sender's last FrameData on a stream (half-close).
+	FlagEndStream
+	// FlagMore marks a continuation: the message goes on in the next frame of
+	// the same type and stream. Only request, response and data frames are split.
+	FlagMore
+	// FlagOneway marks a FrameRequest that expects no response.
+	FlagOneway
+	// FlagBatch marks a FrameRequest carrying a BatchRequest and the
+	// FrameResponse carrying its BatchResponse.
+	FlagBatch
+)
+
+// HeaderSize is the encoded size of a Header: type(1) flags(1) stream id(4).
+const HeaderSize = 6
+
+var ErrShortFrame = errors.New("frame shorter than header")
+
+// Header precedes every frame body. Stream ids are allocated by the side that
+// opens the call: odd ids by clients, even ids by servers.
+type Header struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+}
+
+func (h Header) Has(flag uint8) bool {
+	return h.Flags&flag != 0
+}
+
+func (h Header) encode(b []byte) {
+	b[0] = byte(h.Type)
+	b[1] = h.Flags
+	binary.BigEndian.PutUint32(b[2:], h.StreamID)
+}
+
+func decodeHeader(b []byte) (Header, error) {
+	if len(b) < HeaderSize {
+		return Header{}, ErrShortFrame
+	}
+	return Header{
+		Type:     FrameType(b[0]),
+		Flags:    b[1],
+		StreamID: binary.BigEndian.Uint32(b[2:]),
+	}, nil
+}
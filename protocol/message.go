@@ -3,9 +3,22 @@ package protocol
 type Request struct {
 	Method string  // e.g., "UserService.GetUser"
 	Params *[]byte // 参数的序列化数据
+
+	Metadata map[string]string `json:",omitempty"` // 调用方附带的元数据
 }
 
 type Response struct {
 	Data  *[]byte // 序列化返回值
 	Error string  // 错误信息
 }
+
+// BatchRequest 在一次往返中携带多个调用
+type BatchRequest struct {
+	Requests   []Request
+	Sequential bool `json:",omitempty"` // 按顺序逐个执行，否则并行执行
+}
+
+// BatchResponse 与 BatchRequest 中的调用一一对应
+type BatchResponse struct {
+	Responses []Response
+}
@@ -0,0 +1,100 @@
+// Package reflection implements the Reflection service, which lets clients and
+// tools discover the methods a server exposes and, for methods registered with
+// registry.NewUnaryMethod or registry.NewStreamMethod, the JSON Schema of their
+// requests and responses.
+package reflection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+)
+
+// ServiceName is the name the Reflection service is registered under.
+const ServiceName = "Reflection"
+
+type ListReq struct{}
+
+type ListResp struct {
+	Services []ServiceInfo `json:"services"`
+}
+
+type ServiceInfo struct {
+	Name    string       `json:"name"`
+	Methods []MethodInfo `json:"methods"`
+}
+
+// MethodInfo describes one method. Request and Response are only set by
+// Describe, and only if the method's types are known.
+type MethodInfo struct {
+	Name     string  `json:"name"` // Service.Method
+	Kind     string  `json:"kind"` // "unary" or "stream"
+	Request  *Schema `json:"request,omitempty"`
+	Response *Schema `json:"response,omitempty"`
+}
+
+type DescribeReq struct {
+	Method string `json:"method"` // Service.Method
+}
+
+// Server is the Reflection service. It describes the registry it is
+// registered with.
+type Server struct {
+	r *registry.Registry
+}
+
+func (s *Server) Name() string {
+	return ServiceName
+}
+
+func (s *Server) Register(r *registry.Registry, c codec.Codec) {
+	s.r = r
+	r.ServiceMethods[ServiceName+".List"] = registry.NewUnaryMethod(c, s.List)
+	r.ServiceMethods[ServiceName+".Describe"] = registry.NewUnaryMethod(c, s.Describe)
+}
+
+// List returns every registered method grouped by service, sorted by name.
+func (s *Server) List(*ListReq) (*ListResp, error) {
+	byService := make(map[string][]MethodInfo)
+	for name, m := range s.r.Methods() {
+		service := name
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			service = name[:i]
+		}
+		byService[service] = append(byService[service], MethodInfo{Name: name, Kind: kind(m)})
+	}
+
+	resp := &ListResp{Services: make([]ServiceInfo, 0, len(byService))}
+	for service, methods := range byService {
+		sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+		resp.Services = append(resp.Services, ServiceInfo{Name: service, Methods: methods})
+	}
+	sort.Slice(resp.Services, func(i, j int) bool { return resp.Services[i].Name < resp.Services[j].Name })
+	return resp, nil
+}
+
+// Describe returns req.Method with its request and response schemas.
+func (s *Server) Describe(req *DescribeReq) (*MethodInfo, error) {
+	m, ok := s.r.Method(req.Method)
+	if !ok {
+		return nil, fmt.Errorf("reflection: unknown method %q", req.Method)
+	}
+	info := &MethodInfo{Name: req.Method, Kind: kind(m)}
+	if m.RequestType != nil {
+		info.Request = SchemaOf(m.RequestType)
+	}
+	if m.ResponseType != nil {
+		info.Response = SchemaOf(m.ResponseType)
+	}
+	return info, nil
+}
+
+func kind(m *registry.ServiceMethod) string {
+	if m.StreamHandler != nil {
+		return "stream"
+	}
+	return "unary"
+}
@@ -0,0 +1,124 @@
+package reflection
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+)
+
+type base struct {
+	ID int64 `json:"id"`
+}
+
+type node struct {
+	base
+	Name     string            `json:"name,omitempty"`
+	Created  time.Time         `json:"created"`
+	Raw      []byte            `json:"raw"`
+	Pair     [2]float64        `json:"pair"`
+	Labels   map[string]string `json:"labels"`
+	Children []*node           `json:"children"`
+	Any      any               `json:"any"`
+	Skipped  string            `json:"-"`
+	hidden   int
+}
+
+type reply struct {
+	OK bool
+}
+
+func TestSchemaOf(t *testing.T) {
+	got, err := json.Marshal(SchemaOf(reflect.TypeOf(&node{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"object","properties":{` +
+		`"any":{},` +
+		`"children":{"type":"array","items":{"type":"object"}},` +
+		`"created":{"type":"string","format":"date-time"},` +
+		`"id":{"type":"integer"},` +
+		`"labels":{"type":"object","additionalProperties":{"type":"string"}},` +
+		`"name":{"type":"string"},` +
+		`"pair":{"type":"array","items":{"type":"number"},"minItems":2,"maxItems":2},` +
+		`"raw":{"type":"string","contentEncoding":"base64"}}}`
+	if string(got) != want {
+		t.Fatalf("schema\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestListDescribe(t *testing.T) {
+	r := registry.NewRegister()
+	c := &codec.JsoniterCodec{}
+	r.ServiceMethods["Tree.Get"] = registry.NewUnaryMethod(c, func(*node) (*reply, error) { return &reply{}, nil })
+	r.ServiceMethods["Tree.Raw"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	s := &Server{}
+	r.Register(s, c)
+
+	list, err := s.List(&ListReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Services) != 2 || list.Services[0].Name != ServiceName || list.Services[1].Name != "Tree" ||
+		len(list.Services[1].Methods) != 2 || list.Services[1].Methods[0] != (MethodInfo{Name: "Tree.Get", Kind: "unary"}) {
+		t.Fatalf("list = %+v", list)
+	}
+
+	info, err := s.Describe(&DescribeReq{Method: "Tree.Get"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Request == nil || info.Request.Properties["id"] == nil || info.Response.Properties["OK"].Type != "boolean" {
+		t.Fatalf("describe = %+v", info)
+	}
+	if info, _ := s.Describe(&DescribeReq{Method: "Tree.Raw"}); info.Request != nil {
+		t.Fatal("untyped method has a schema")
+	}
+	if _, err := s.Describe(&DescribeReq{Method: "Tree.Missing"}); err == nil {
+		t.Fatal("unknown method: expected an error")
+	}
+}
+
+// treeService registers Tree.Get under its own name.
+type treeService string
+
+func (s treeService) Name() string { return string(s) }
+func (s treeService) Register(r *registry.Registry, c codec.Codec) {
+	r.ServiceMethods[string(s)+".Get"] = registry.NewUnaryMethod(c, func(*node) (*reply, error) { return &reply{}, nil })
+}
+
+func TestListWhileRegistering(t *testing.T) {
+	r := registry.NewRegister()
+	c := &codec.JsoniterCodec{}
+	s := &Server{}
+	r.Register(s, c)
+
+	const n = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			r.Register(treeService(fmt.Sprintf("Tree%d", i)), c)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if _, err := s.List(&ListReq{}); err != nil {
+			t.Fatal(err)
+		}
+		s.Describe(&DescribeReq{Method: "Tree0.Get"})
+	}
+
+	list, _ := s.List(&ListReq{})
+	if len(list.Services) != n+1 {
+		t.Fatalf("%d services listed, want %d", len(list.Services), n+1)
+	}
+}
@@ -0,0 +1,16 @@
+package registry
+
+import "context"
+
+type metadataKey struct{}
+
+// NewContext 返回携带请求元数据 md 的 ctx 副本
+func NewContext(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext 返回处理函数所处理请求的元数据，没有时返回 nil
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
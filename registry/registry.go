@@ -1,8 +1,14 @@
 package registry
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
 	"xxrpc/internal/codec"
+	"xxrpc/protocol"
 )
 
 // 调用侧是从Registry中找到对应的服务和方法
@@ -16,31 +22,190 @@ type Service interface {
 
 type HandlerFunc func([]byte) ([]byte, error)
 
+// ContextHandlerFunc 是需要调用上下文的一元处理函数
+// ctx 携带服务端 span（开启追踪时）和请求元数据，见 MetadataFromContext
+type ContextHandlerFunc func(ctx context.Context, params []byte) ([]byte, error)
+
 type ServiceMethod struct {
-	Handler HandlerFunc
+	Handler        HandlerFunc        // 一元调用
+	ContextHandler ContextHandlerFunc // 需要上下文的一元调用，设置后优先于 Handler
+	StreamHandler  StreamHandlerFunc  // 流式调用，与 Handler 二选一
+
+	// 请求和响应的类型，由 NewUnaryMethod / NewStreamMethod 填写
+	// 反射服务据此生成 JSON Schema，手写 Handler 时可以留空
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+}
+
+// NewUnaryMethod 用 c 包装带类型的一元方法，并记录请求和响应类型
+func NewUnaryMethod[Req, Resp any](c codec.Codec, fn func(*Req) (*Resp, error)) *ServiceMethod {
+	return &ServiceMethod{
+		Handler: func(data []byte) ([]byte, error) {
+			req := new(Req)
+			if err := c.Unmarshal(data, req); err != nil {
+				return nil, err
+			}
+			resp, err := fn(req)
+			if err != nil {
+				return nil, err
+			}
+			return c.Marshal(resp)
+		},
+		RequestType:  typeOf[Req](),
+		ResponseType: typeOf[Resp](),
+	}
+}
+
+// NewUnaryContextMethod 同 NewUnaryMethod，fn 额外收到调用的上下文
+func NewUnaryContextMethod[Req, Resp any](c codec.Codec, fn func(context.Context, *Req) (*Resp, error)) *ServiceMethod {
+	return &ServiceMethod{
+		ContextHandler: func(ctx context.Context, data []byte) ([]byte, error) {
+			req := new(Req)
+			if err := c.Unmarshal(data, req); err != nil {
+				return nil, err
+			}
+			resp, err := fn(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return c.Marshal(resp)
+		},
+		RequestType:  typeOf[Req](),
+		ResponseType: typeOf[Resp](),
+	}
+}
+
+// NewStreamMethod 同 NewStreamHandler，并记录流上收发的消息类型
+func NewStreamMethod[Req, Resp any](fn func(ServerStream[Req, Resp]) error) *ServiceMethod {
+	return &ServiceMethod{
+		StreamHandler: NewStreamHandler(fn),
+		RequestType:   typeOf[Req](),
+		ResponseType:  typeOf[Resp](),
+	}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
 }
 
 type Registry struct {
+	// 服务在 Service.Register 中写入方法；在 Registry.Register 之外直接写入
+	// 只能发生在服务开始处理请求之前，之后用 Methods / Method 读取
 	ServiceMethods map[string]*ServiceMethod
+
+	mu       sync.RWMutex
+	services map[string]Service
 }
 
 func NewRegister() *Registry {
 	return &Registry{
 		ServiceMethods: make(map[string]*ServiceMethod),
+		services:       make(map[string]Service),
 	}
 }
 
 // Register 注册一个服务
 func (r *Registry) Register(svc Service, c codec.Codec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	svc.Register(r, c)
+	if r.services == nil {
+		r.services = make(map[string]Service)
+	}
+	r.services[svc.Name()] = svc
 	return nil
 }
 
-// Find 找到某一个服务的方法
+// Services 返回已注册的服务名称（按名称排序）
+func (r *Registry) Services() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Methods 返回已注册方法的快照，可以在注册新服务的同时读取
+func (r *Registry) Methods() map[string]*ServiceMethod {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	methods := make(map[string]*ServiceMethod, len(r.ServiceMethods))
+	for name, m := range r.ServiceMethods {
+		methods[name] = m
+	}
+	return methods
+}
+
+// Method 返回名为 serviceMethodName 的方法
+func (r *Registry) Method(serviceMethodName string) (*ServiceMethod, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.ServiceMethods[serviceMethodName]
+	return m, ok
+}
+
+// Find 找到某一个服务的方法，ContextHandler 以空上下文调用
 func (r *Registry) Find(serviceMethodName string) (HandlerFunc, error) {
-	serviceMethod, ok := r.ServiceMethods[serviceMethodName]
-	if !ok {
-		return nil, fmt.Errorf("serviceMethodName %s not found ", serviceMethodName)
+	handler, err := r.find(serviceMethodName)
+	if err != nil {
+		return nil, err
+	}
+	return func(params []byte) ([]byte, error) {
+		return handler(context.Background(), params)
+	}, nil
+}
+
+func (r *Registry) find(serviceMethodName string) (ContextHandlerFunc, error) {
+	serviceMethod, ok := r.Method(serviceMethodName)
+	switch {
+	case ok && serviceMethod.ContextHandler != nil:
+		return serviceMethod.ContextHandler, nil
+	case ok && serviceMethod.Handler != nil:
+		handler := serviceMethod.Handler
+		return func(_ context.Context, params []byte) ([]byte, error) {
+			return handler(params)
+		}, nil
+	}
+	return nil, fmt.Errorf("serviceMethodName %s not found ", serviceMethodName)
+}
+
+// Invoke 同 InvokeContext，使用只携带请求元数据的上下文
+func (r *Registry) Invoke(req *protocol.Request, resp *protocol.Response) error {
+	return r.InvokeContext(NewContext(context.Background(), req.Metadata), req, resp)
+}
+
+// InvokeContext 找到请求对应的方法并以 ctx 执行，结果和错误写入 resp
+// 只有方法不存在时返回错误，方法自身的错误只写入 resp.Error
+func (r *Registry) InvokeContext(ctx context.Context, req *protocol.Request, resp *protocol.Response) error {
+	handler, err := r.find(req.Method)
+	if err != nil {
+		resp.Error = err.Error()
+		return err
+	}
+
+	var params []byte
+	if req.Params != nil {
+		params = *req.Params
+	}
+	respData, err := handler(ctx, params)
+	if err != nil {
+		resp.Error = err.Error()
+		return nil
+	}
+
+	resp.Data = &respData
+	resp.Error = ""
+	return nil
+}
+
+// FindStream 找到某一个服务的流式方法
+func (r *Registry) FindStream(serviceMethodName string) (StreamHandlerFunc, error) {
+	serviceMethod, ok := r.Method(serviceMethodName)
+	if !ok || serviceMethod.StreamHandler == nil {
+		return nil, fmt.Errorf("stream serviceMethodName %s not found ", serviceMethodName)
 	}
-	return serviceMethod.Handler, nil
+	return serviceMethod.StreamHandler, nil
 }
@@ -0,0 +1,45 @@
+package registry
+
+import "context"
+
+// Stream 是服务端流式方法看到的双向流
+// 客户端流、服务端流和双向流都通过它实现，区别只在于各自收发消息的次数
+type Stream interface {
+	Method() string
+	Metadata() map[string]string
+	// Context 携带服务端 span（开启追踪时）和流的元数据
+	Context() context.Context
+	// SendMsg 编码并发送一条消息，对端接收窗口用尽时阻塞
+	SendMsg(m any) error
+	// RecvMsg 接收并解码一条消息，对端半关闭后返回 io.EOF
+	RecvMsg(m any) error
+	// Done 在流结束（处理函数返回、对端重置或连接断开）时关闭
+	Done() <-chan struct{}
+}
+
+// StreamHandlerFunc 处理一个流，返回值作为流的最终状态发给客户端
+type StreamHandlerFunc func(Stream) error
+
+// ServerStream 是带类型的 Stream，Req 为客户端发来的消息类型，Resp 为返回的消息类型
+type ServerStream[Req, Resp any] struct {
+	Stream
+}
+
+func (s ServerStream[Req, Resp]) Recv() (*Req, error) {
+	req := new(Req)
+	if err := s.RecvMsg(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s ServerStream[Req, Resp]) Send(resp *Resp) error {
+	return s.SendMsg(resp)
+}
+
+// NewStreamHandler 将带类型的流式方法转换为 StreamHandlerFunc
+func NewStreamHandler[Req, Resp any](fn func(ServerStream[Req, Resp]) error) StreamHandlerFunc {
+	return func(s Stream) error {
+		return fn(ServerStream[Req, Resp]{Stream: s})
+	}
+}
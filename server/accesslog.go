@@ -0,0 +1,93 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AccessLogConfig controls the per-call access log written to the server's logger.
+type AccessLogConfig struct {
+	// SampleRate is the fraction of calls logged, from 0 to 1.
+	SampleRate float64
+	// SlowThreshold logs every call taking at least this long, regardless of
+	// sampling, at warn level. Zero disables it.
+	SlowThreshold time.Duration
+	// Redact, if set, replaces metadata values before they are logged, e.g. to
+	// hide tokens. See RedactKeys.
+	Redact func(key, value string) string
+}
+
+// DefaultAccessLogConfig logs every call and warns about calls slower than 1s.
+func DefaultAccessLogConfig() AccessLogConfig {
+	return AccessLogConfig{
+		SampleRate:    1,
+		SlowThreshold: time.Second,
+	}
+}
+
+// RedactKeys returns a Redact hook that masks the values of the given metadata keys.
+func RedactKeys(keys ...string) func(key, value string) string {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return func(key, value string) string {
+		if _, ok := set[key]; ok {
+			return "[REDACTED]"
+		}
+		return value
+	}
+}
+
+// WithAccessLog logs one line per call: method, peer, latency, sizes, status
+// code, trace ID and metadata.
+func WithAccessLog(cfg AccessLogConfig) Option {
+	return optionFunc(func(srv *Server) {
+		srv.accessLog = &cfg
+	})
+}
+
+func (s *Server) logAccess(o *observation, code string, latency time.Duration, reqBytes, respBytes int, errMsg string) {
+	cfg := s.accessLog
+	slow := cfg.SlowThreshold > 0 && latency >= cfg.SlowThreshold
+	if !slow && (cfg.SampleRate <= 0 || cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate) {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("method", o.method),
+		zap.String("peer", o.peer),
+		zap.Duration("latency", latency),
+		zap.String("code", code),
+	}
+	if reqBytes >= 0 {
+		fields = append(fields, zap.Int("req_bytes", reqBytes))
+	}
+	if respBytes >= 0 {
+		fields = append(fields, zap.Int("resp_bytes", respBytes))
+	}
+	if errMsg != "" {
+		fields = append(fields, zap.String("error", errMsg))
+	}
+	if traceID := o.traceID(); traceID != "" {
+		fields = append(fields, zap.String("trace_id", traceID))
+	}
+	if len(o.md) > 0 {
+		md := make(map[string]string, len(o.md))
+		for k, v := range o.md {
+			if cfg.Redact != nil {
+				v = cfg.Redact(k, v)
+			}
+			md[k] = v
+		}
+		fields = append(fields, zap.Any("metadata", md))
+	}
+
+	if slow {
+		s.logger.Warn("slow call", fields...)
+		return
+	}
+	s.logger.Info("access", fields...)
+}
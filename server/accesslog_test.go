@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"xxrpc/metrics"
+	"xxrpc/registry"
+)
+
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := AccessLogConfig{
+		SampleRate:    0, // only slow calls
+		SlowThreshold: 50 * time.Millisecond,
+		Redact:        RedactKeys("token"),
+	}
+	s := NewServer(":0", registry.NewRegister(), WithLogger(zap.New(core)), WithAccessLog(cfg))
+
+	md := map[string]string{
+		"token":       "secret",
+		"user":        "u1",
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	fast := &observation{s: s, method: "Echo.Say", peer: "10.0.0.1:5000", md: md, start: time.Now()}
+	fast.done(metrics.CodeOK, 3, 5, "")
+	slow := &observation{s: s, method: "Echo.Say", peer: "10.0.0.1:5000", md: md, start: time.Now().Add(-time.Second)}
+	slow.done(metrics.CodeError, 3, 5, "boom")
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want only the slow call", len(entries))
+	}
+	e := entries[0]
+	if e.Level != zapcore.WarnLevel || e.Message != "slow call" {
+		t.Fatalf("entry = %v %q", e.Level, e.Message)
+	}
+	fields := e.ContextMap()
+	loggedMD := fields["metadata"].(map[string]string)
+	if loggedMD["token"] != "[REDACTED]" || loggedMD["user"] != "u1" {
+		t.Fatalf("metadata = %v", loggedMD)
+	}
+	if fields["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || fields["code"] != metrics.CodeError || fields["error"] != "boom" {
+		t.Fatalf("fields = %v", fields)
+	}
+	if md["token"] != "secret" {
+		t.Fatal("redaction modified the request metadata")
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"xxrpc/internal/buffer"
+)
+
+// WithLogLevel lets the admin handler change lvl at runtime. lvl should be the
+// level the server's logger was built with.
+func WithLogLevel(lvl zap.AtomicLevel) Option {
+	return optionFunc(func(srv *Server) {
+		srv.logLevel = &lvl
+	})
+}
+
+// AdminHandler returns an HTTP handler for inspecting the running server. It is
+// not mounted anywhere by default; serve it on an internal address, e.g.
+//
+//	http.Handle("/debug/xxrpc/", http.StripPrefix("/debug/xxrpc", s.AdminHandler()))
+//
+// Endpoints, all returning JSON:
+//
+//	GET      /methods      registered methods
+//	GET      /connections  open connections and the calls in flight on each
+//	GET      /pool         buffer pool counters
+//	GET      /config       the server's configuration
+//	GET|PUT  /loglevel     the log level, see WithLogLevel and zap.AtomicLevel
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/methods", s.adminMethods)
+	mux.HandleFunc("/connections", s.adminConnections)
+	mux.HandleFunc("/pool", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, buffer.GetStats())
+	})
+	mux.HandleFunc("/config", s.adminConfig)
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if s.logLevel == nil {
+			http.Error(w, "log level is not adjustable, see server.WithLogLevel", http.StatusNotFound)
+			return
+		}
+		s.logLevel.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+type adminMethod struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // "unary" or "stream"
+}
+
+func (s *Server) adminMethods(w http.ResponseWriter, _ *http.Request) {
+	registered := s.registry.Methods()
+	methods := make([]adminMethod, 0, len(registered))
+	for name, m := range registered {
+		kind := "unary"
+		if m.StreamHandler != nil {
+			kind = "stream"
+		}
+		methods = append(methods, adminMethod{Name: name, Kind: kind})
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	writeJSON(w, methods)
+}
+
+type adminConn struct {
+	Remote     string `json:"remote"`
+	Local      string `json:"local"`
+	Calls      int    `json:"calls"`
+	Streams    int    `json:"streams"`
+	Handling   int    `json:"handling"`
+	SendWindow int    `json:"send_window"`
+}
+
+func (s *Server) adminConnections(w http.ResponseWriter, _ *http.Request) {
+	peers := s.Peers()
+	conns := make([]adminConn, 0, len(peers))
+	for _, p := range peers {
+		st := p.conn.Stats()
+		conns = append(conns, adminConn{
+			Remote:     p.conn.RemoteAddr().String(),
+			Local:      p.conn.LocalAddr().String(),
+			Calls:      st.Calls,
+			Streams:    st.Streams,
+			Handling:   st.Handling,
+			SendWindow: st.SendWindow,
+		})
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Remote < conns[j].Remote })
+	writeJSON(w, conns)
+}
+
+type adminAccessLog struct {
+	SampleRate    float64 `json:"sample_rate"`
+	SlowThreshold string  `json:"slow_threshold"`
+	Redacted      bool    `json:"redacted"`
+}
+
+type adminConfig struct {
+	Addr               string            `json:"addr"`
+	Codec              string            `json:"codec"`
+	MaxMessageSize     int               `json:"max_message_size,omitempty"`
+	MaxPendingRequests int               `json:"max_pending_requests,omitempty"`
+	PingInterval       string            `json:"ping_interval,omitempty"`
+	PingTimeout        string            `json:"ping_timeout,omitempty"`
+	IdleTimeout        string            `json:"idle_timeout,omitempty"`
+	Registrar          string            `json:"registrar,omitempty"`
+	RegisterTTL        string            `json:"register_ttl,omitempty"`
+	AdvertiseAddr      string            `json:"advertise_addr,omitempty"`
+	InstanceWeight     int               `json:"instance_weight,omitempty"`
+	InstanceMetadata   map[string]string `json:"instance_metadata,omitempty"`
+	Metrics            bool              `json:"metrics"`
+	Tracing            bool              `json:"tracing"`
+	Reflection         bool              `json:"reflection"`
+	AccessLog          *adminAccessLog   `json:"access_log,omitempty"`
+	LogLevel           string            `json:"log_level,omitempty"`
+}
+
+func (s *Server) adminConfig(w http.ResponseWriter, _ *http.Request) {
+	cfg := adminConfig{
+		Addr:               s.addr,
+		Codec:              fmt.Sprintf("%T", s.codec),
+		MaxMessageSize:     s.maxMessageSize,
+		MaxPendingRequests: s.maxPendingRequests,
+		PingInterval:       duration(s.pingInterval),
+		PingTimeout:        duration(s.pingTimeout),
+		IdleTimeout:        duration(s.idleTimeout),
+		RegisterTTL:        duration(s.registerTTL),
+		AdvertiseAddr:      s.advertiseAddr,
+		InstanceWeight:     s.instanceWeight,
+		InstanceMetadata:   s.instanceMetadata,
+		Metrics:            s.metrics != nil,
+		Tracing:            s.tracer != nil,
+		Reflection:         s.reflection,
+	}
+	if s.registrar != nil {
+		cfg.Registrar = fmt.Sprintf("%T", s.registrar)
+	}
+	if s.accessLog != nil {
+		cfg.AccessLog = &adminAccessLog{
+			SampleRate:    s.accessLog.SampleRate,
+			SlowThreshold: duration(s.accessLog.SlowThreshold),
+			Redacted:      s.accessLog.Redact != nil,
+		}
+	}
+	if s.logLevel != nil {
+		cfg.LogLevel = s.logLevel.String()
+	}
+	writeJSON(w, cfg)
+}
+
+func duration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
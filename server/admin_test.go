@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"xxrpc/internal/codec"
+	"xxrpc/registry"
+)
+
+func TestAdminHandler(t *testing.T) {
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	reg.ServiceMethods["Echo.Watch"] = &registry.ServiceMethod{StreamHandler: func(registry.Stream) error { return nil }}
+
+	lvl := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	s := NewServer(":8888", reg, WithLogLevel(lvl), WithMaxPendingRequests(16))
+	h := s.AdminHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/methods", nil))
+	var methods []adminMethod
+	if err := json.Unmarshal(rec.Body.Bytes(), &methods); err != nil {
+		t.Fatal(err)
+	}
+	// the built-in Health service is listed after Echo
+	if len(methods) != 4 || methods[0] != (adminMethod{"Echo.Say", "unary"}) || methods[1] != (adminMethod{"Echo.Watch", "stream"}) ||
+		methods[2] != (adminMethod{"Health.Check", "unary"}) {
+		t.Fatalf("methods = %+v", methods)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/config", nil))
+	var cfg adminConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8888" || cfg.MaxPendingRequests != 16 || cfg.LogLevel != "info" {
+		t.Fatalf("config = %+v", cfg)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	if rec.Code != http.StatusOK || lvl.Level() != zapcore.DebugLevel {
+		t.Fatalf("loglevel: %d %s, level %v", rec.Code, rec.Body, lvl.Level())
+	}
+}
+
+// methodService registers a single unary method named after itself.
+type methodService string
+
+func (s methodService) Name() string { return string(s) }
+func (s methodService) Register(r *registry.Registry, _ codec.Codec) {
+	r.ServiceMethods[string(s)+".Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+}
+
+func TestAdminMethodsWhileRegistering(t *testing.T) {
+	s := NewServer(":8888", registry.NewRegister())
+	h := s.AdminHandler()
+
+	const n = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			s.Register(methodService(fmt.Sprintf("Svc%d", i)))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/methods", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/methods", nil))
+	var methods []adminMethod
+	if err := json.Unmarshal(rec.Body.Bytes(), &methods); err != nil {
+		t.Fatal(err)
+	}
+	// plus Health.Check and Health.Watch
+	if len(methods) != n+2 {
+		t.Fatalf("%d methods listed, want %d", len(methods), n+2)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"xxrpc/internal/transport"
+	"xxrpc/metrics"
+	"xxrpc/protocol"
+	"xxrpc/registry"
+	"xxrpc/tracing"
+)
+
+// WithTracerProvider creates a server span for every call, continuing the
+// client's trace when the request metadata carries a traceparent.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return optionFunc(func(srv *Server) {
+		srv.tracer = tracing.New(tp)
+	})
+}
+
+// observation records one served call in the server's metrics and traces.
+type observation struct {
+	s      *Server
+	method string
+	label  string // method as a metrics label, see metricsLabel
+	peer   string
+	md     map[string]string
+	start  time.Time
+	span   *tracing.Span
+	ctx    context.Context // handed to the handler: the server span and md
+}
+
+func (s *Server) observe(c *transport.Conn, method string, md map[string]string) *observation {
+	o := &observation{s: s, method: method, label: s.metricsLabel(method), md: md, start: time.Now()}
+	s.metrics.Start(o.label)
+	if s.tracer != nil || s.accessLog != nil {
+		o.peer = c.RemoteAddr().String()
+	}
+	var ctx context.Context
+	ctx, o.span = s.tracer.StartServer(method, o.peer, md)
+	o.ctx = registry.NewContext(ctx, md)
+	return o
+}
+
+// done ends the call; negative sizes are unknown and not recorded.
+func (o *observation) done(code string, reqBytes, respBytes int, errMsg string) {
+	o.s.metrics.Done(o.label, code, o.start, reqBytes, respBytes)
+	o.span.End(code, reqBytes, respBytes, errMsg)
+	if o.s.accessLog != nil {
+		o.s.logAccess(o, code, time.Since(o.start), reqBytes, respBytes, errMsg)
+	}
+}
+
+// observeReject counts a request the transport refused without serving it.
+func (s *Server) observeReject(method string, err error) {
+	code := metrics.CodeError
+	switch err {
+	case transport.ErrTooManyRequests:
+		code = metrics.CodeTooManyRequests
+	case transport.ErrMessageTooLarge:
+		code = metrics.CodeTooLarge
+	}
+	s.metrics.Rejected(s.metricsLabel(method), code)
+}
+
+// metricsLabel returns method, or metrics.MethodUnknown if it is not registered.
+func (s *Server) metricsLabel(method string) string {
+	if _, ok := s.registry.Method(method); !ok {
+		return metrics.MethodUnknown
+	}
+	return method
+}
+
+// traceID prefers the server span's trace, which exists even if the client
+// sent no traceparent.
+func (o *observation) traceID() string {
+	if o.span != nil {
+		return o.span.TraceID()
+	}
+	return tracing.TraceID(o.md)
+}
+
+// statusCode classifies a served call; Invoke only fails for unknown methods.
+func statusCode(resp *protocol.Response, invokeErr error) string {
+	switch {
+	case invokeErr != nil:
+		return metrics.CodeNotFound
+	case resp.Error != "":
+		return metrics.CodeError
+	}
+	return metrics.CodeOK
+}
+
+func size(b *[]byte) int {
+	if b == nil {
+		return 0
+	}
+	return len(*b)
+}
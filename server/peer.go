@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net"
+
+	"xxrpc/internal/transport"
+	"xxrpc/protocol"
+	"xxrpc/registry"
+)
+
+// Peer is the client end of one connection. The server can call methods the
+// client registered (see client.WithRegistry) over the same connection.
+type Peer struct {
+	conn *transport.Conn
+}
+
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// Done is closed once the connection has gone away.
+func (p *Peer) Done() <-chan struct{} {
+	return p.conn.Done()
+}
+
+// Call invokes a method registered on the client and waits for its response.
+func (p *Peer) Call(serviceMethod string, args any) (*protocol.Response, error) {
+	payload, err := p.conn.Codec().Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := p.conn.StartCall(&protocol.Request{Method: serviceMethod, Params: &payload})
+	if err != nil {
+		return nil, err
+	}
+	<-pc.Done
+	return pc.Resp, pc.Err
+}
+
+// CallOneway sends a notification to the client without waiting for a response.
+func (p *Peer) CallOneway(serviceMethod string, args any) error {
+	payload, err := p.conn.Codec().Marshal(args)
+	if err != nil {
+		return err
+	}
+	return p.conn.SendOneway(&protocol.Request{Method: serviceMethod, Params: &payload})
+}
+
+// Close drops the connection.
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+// WithOnConnect calls fn in its own goroutine for every accepted connection.
+func WithOnConnect(fn func(*Peer)) Option {
+	return optionFunc(func(srv *Server) {
+		srv.onConnect = fn
+	})
+}
+
+// WithOnDisconnect calls fn once a connection has gone away.
+func WithOnDisconnect(fn func(*Peer)) Option {
+	return optionFunc(func(srv *Server) {
+		srv.onDisconnect = fn
+	})
+}
+
+// Peers returns the currently connected clients.
+func (s *Server) Peers() []*Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// PeerOf returns the client that opened st, so a stream handler can call back
+// into it. It returns nil if st was not opened on this server.
+func (s *Server) PeerOf(st registry.Stream) *Peer {
+	ts, ok := st.(*transport.Stream)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peers[ts.Conn()]
+}
+
+func (s *Server) addPeer(tc *transport.Conn) *Peer {
+	p := &Peer{conn: tc}
+	s.mu.Lock()
+	s.peers[tc] = p
+	s.mu.Unlock()
+
+	if s.onConnect != nil {
+		go s.onConnect(p)
+	}
+	return p
+}
+
+func (s *Server) removePeer(p *Peer) {
+	s.mu.Lock()
+	delete(s.peers, p.conn)
+	s.mu.Unlock()
+
+	if s.onDisconnect != nil {
+		s.onDisconnect(p)
+	}
+}
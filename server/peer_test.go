@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"xxrpc/client"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+)
+
+func TestPeerCallsClient(t *testing.T) {
+	connected := make(chan *Peer, 1)
+	disconnected := make(chan *Peer, 1)
+	s := NewServer("memconn", registry.NewRegister(),
+		WithOnConnect(func(p *Peer) { connected <- p }),
+		WithOnDisconnect(func(p *Peer) { disconnected <- p }))
+	s.registry.ServiceMethods["Sub.Watch"] = &registry.ServiceMethod{StreamHandler: func(st registry.Stream) error {
+		p := s.PeerOf(st)
+		if p == nil {
+			return errors.New("no peer for stream")
+		}
+		resp, err := p.Call("Notify.Push", "from stream")
+		if err != nil {
+			return err
+		}
+		return st.SendMsg(json.RawMessage(*resp.Data))
+	}}
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	events := make(chan string, 1)
+	reg := registry.NewRegister()
+	reg.ServiceMethods["Notify.Push"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) {
+		return append([]byte(`"ack `), b[1:]...), nil
+	}}
+	reg.ServiceMethods["Notify.Event"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) {
+		events <- string(b)
+		return nil, nil
+	}}
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext), client.WithRegistry(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	var p *Peer
+	select {
+	case p = <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect was not called")
+	}
+
+	resp, err := p.Call("Notify.Push", "hi")
+	if err != nil || resp.Error != "" || string(*resp.Data) != `"ack hi"` {
+		t.Fatalf("Call = %v, %v", resp, err)
+	}
+	if resp, err := p.Call("Notify.Nope", "hi"); err != nil || resp.Error == "" {
+		t.Fatalf("Call of unregistered method = %v, %v", resp, err)
+	}
+
+	if err := p.CallOneway("Notify.Event", "ping"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-events:
+		if got != `"ping"` {
+			t.Fatalf("event = %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("one-way call did not arrive")
+	}
+
+	// a stream handler finds the peer that opened the stream and calls it back
+	st, err := cli.NewStream("Sub.Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg json.RawMessage
+	if err := st.RecvMsg(&msg); err != nil || string(msg) != `"ack from stream"` {
+		t.Fatalf("stream message = %s, %v", msg, err)
+	}
+	st.Close()
+
+	cli.Close()
+	select {
+	case got := <-disconnected:
+		if got != p {
+			t.Fatal("OnDisconnect got another peer")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect was not called")
+	}
+	if len(s.Peers()) != 0 {
+		t.Fatalf("%d peers left after disconnect", len(s.Peers()))
+	}
+}
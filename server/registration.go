@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+
+	"xxrpc/discovery"
+	"xxrpc/health"
+	"xxrpc/reflection"
+)
+
+// register publishes every registered service and starts the TTL heartbeat.
+func (s *Server) register(listenAddr net.Addr) error {
+	if s.registrar == nil {
+		return nil
+	}
+
+	addr := s.advertiseAddr
+	if addr == "" {
+		addr = advertiseAddr(listenAddr)
+	}
+
+	instances := make([]discovery.Instance, 0)
+	for _, name := range s.registry.Services() {
+		if name == health.ServiceName || name == reflection.ServiceName {
+			continue
+		}
+		instances = append(instances, discovery.Instance{
+			Service:  name,
+			Addr:     addr,
+			Weight:   s.instanceWeight,
+			Metadata: s.instanceMetadata,
+		})
+	}
+
+	for i, ins := range instances {
+		if err := s.registrar.Register(ins, s.registerTTL); err != nil {
+			s.logger.Error("failed to register service", zap.String("service", ins.Service), zap.Error(err))
+			// don't leave the server half published when it is not going to serve
+			s.deregisterInstances(instances[:i])
+			return err
+		}
+		s.logger.Info("registered service", zap.String("service", ins.Service), zap.String("address", ins.Addr))
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		// Stop ran while registering and found nothing to deregister
+		s.mu.Unlock()
+		s.deregisterInstances(instances)
+		return nil
+	default:
+	}
+	s.instances = instances
+	if s.registerTTL > 0 {
+		s.heartbeat.Add(1)
+		go s.renew(instances)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// renew refreshes the registrations every third of their TTL until Stop.
+func (s *Server) renew(instances []discovery.Instance) {
+	defer s.heartbeat.Done()
+	interval := s.registerTTL / 3
+	if interval <= 0 {
+		interval = s.registerTTL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			for _, ins := range instances {
+				if err := s.registrar.Register(ins, s.registerTTL); err != nil {
+					s.logger.Warn("failed to renew registration", zap.String("service", ins.Service), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// deregister withdraws the registrations once no renewal can re-add them.
+func (s *Server) deregister() {
+	s.heartbeat.Wait()
+	s.mu.Lock()
+	instances := s.instances
+	s.instances = nil
+	s.mu.Unlock()
+	s.deregisterInstances(instances)
+}
+
+func (s *Server) deregisterInstances(instances []discovery.Instance) {
+	for _, ins := range instances {
+		if err := s.registrar.Deregister(ins); err != nil {
+			s.logger.Warn("failed to deregister service", zap.String("service", ins.Service), zap.Error(err))
+		}
+	}
+}
+
+// advertiseAddr turns a wildcard listen address such as ":8888" into one that
+// other hosts can dial, using the first non-loopback IPv4 address.
+func advertiseAddr(listenAddr net.Addr) string {
+	host, port, err := net.SplitHostPort(listenAddr.String())
+	if err != nil {
+		return listenAddr.String()
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return listenAddr.String()
+	}
+
+	host = "127.0.0.1"
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				host = ipnet.IP.String()
+				break
+			}
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
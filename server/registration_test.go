@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"xxrpc/discovery"
+	"xxrpc/internal/codec"
+	"xxrpc/memconn"
+	"xxrpc/registry"
+)
+
+// fakeRegistrar records registrations and fails those of service failOn.
+type fakeRegistrar struct {
+	failOn string
+
+	mu           sync.Mutex
+	registered   map[string]int // service -> Register calls
+	deregistered []string
+	ttl          time.Duration
+	addr         string
+}
+
+func newFakeRegistrar(failOn string) *fakeRegistrar {
+	return &fakeRegistrar{failOn: failOn, registered: make(map[string]int)}
+}
+
+func (r *fakeRegistrar) Register(ins discovery.Instance, ttl time.Duration) error {
+	if ins.Service == r.failOn {
+		return errors.New("registrar unavailable")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered[ins.Service]++
+	r.ttl, r.addr = ttl, ins.Addr
+	return nil
+}
+
+func (r *fakeRegistrar) Deregister(ins discovery.Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deregistered = append(r.deregistered, ins.Service)
+	return nil
+}
+
+func (r *fakeRegistrar) calls(service string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered[service]
+}
+
+// namedService is an empty service that only has a name.
+type namedService string
+
+func (s namedService) Name() string                           { return string(s) }
+func (namedService) Register(*registry.Registry, codec.Codec) {}
+
+func TestRegistration(t *testing.T) {
+	reg := newFakeRegistrar("")
+	s := NewServer("memconn", registry.NewRegister(),
+		WithRegistrar(reg, 60*time.Millisecond), WithAdvertiseAddr("10.0.0.1:8888"), WithReflection())
+	s.Register(namedService("A"))
+	s.Register(namedService("B"))
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(memconn.Listen(0)) }()
+
+	// registered on start, then renewed every TTL/3
+	deadline := time.Now().Add(time.Second)
+	for reg.calls("A") < 3 || reg.calls("B") < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("registered A %d and B %d times, want at least 3 each", reg.calls("A"), reg.calls("B"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	reg.mu.Lock()
+	if len(reg.registered) != 2 || reg.ttl != 60*time.Millisecond || reg.addr != "10.0.0.1:8888" {
+		t.Fatalf("registered %v for %v at %s", reg.registered, reg.ttl, reg.addr)
+	}
+	reg.mu.Unlock()
+
+	s.Stop()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve returned %v", err)
+	}
+	reg.mu.Lock()
+	if len(reg.deregistered) != 2 {
+		t.Fatalf("deregistered %v, want A and B", reg.deregistered)
+	}
+	renewed := reg.registered["A"]
+	reg.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	if reg.calls("A") != renewed {
+		t.Fatal("registration renewed after Stop")
+	}
+}
+
+func TestRegistrationRollback(t *testing.T) {
+	reg := newFakeRegistrar("B")
+	s := NewServer("memconn", registry.NewRegister(), WithRegistrar(reg, time.Minute))
+	for _, name := range []string{"A", "B", "C"} {
+		s.Register(namedService(name))
+	}
+
+	if err := s.Serve(memconn.Listen(0)); err == nil || errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve returned %v, want the registrar's error", err)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.registered["A"] != 1 || reg.registered["C"] != 0 {
+		t.Fatalf("registered %v, want only A", reg.registered)
+	}
+	if len(reg.deregistered) != 1 || reg.deregistered[0] != "A" {
+		t.Fatalf("deregistered %v, want A", reg.deregistered)
+	}
+}
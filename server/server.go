@@ -1,17 +1,29 @@
 package server
 
 import (
+	"errors"
 	"io"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
+	"xxrpc/discovery"
+	"xxrpc/health"
 	"xxrpc/internal/codec"
-	"xxrpc/internal/pool"
+	"xxrpc/internal/transport"
+	"xxrpc/metrics"
 	"xxrpc/protocol"
+	"xxrpc/reflection"
 	"xxrpc/registry"
+	"xxrpc/tracing"
 )
 
+// ErrServerClosed is returned by Start after Stop has been called.
+var ErrServerClosed = errors.New("xxrpc: server closed")
+
 type Option interface {
 	Apply(*Server)
 }
@@ -35,16 +47,129 @@ func WithCodec(c codec.Codec) Option {
 	})
 }
 
+// WithRegistrar advertises every registered service to r when the server starts,
+// renews the registration every ttl/3 and deregisters on Stop.
+func WithRegistrar(r discovery.Registrar, ttl time.Duration) Option {
+	return optionFunc(func(srv *Server) {
+		srv.registrar = r
+		srv.registerTTL = ttl
+	})
+}
+
+// WithAdvertiseAddr sets the address published to the registrar. By default it is
+// derived from the listen address.
+func WithAdvertiseAddr(addr string) Option {
+	return optionFunc(func(srv *Server) {
+		srv.advertiseAddr = addr
+	})
+}
+
+// WithInstanceWeight sets the load balancing weight published to the registrar.
+func WithInstanceWeight(weight int) Option {
+	return optionFunc(func(srv *Server) {
+		srv.instanceWeight = weight
+	})
+}
+
+// WithInstanceMetadata sets the metadata (e.g. discovery.MetadataVersion, discovery.MetadataZone)
+// published to the registrar.
+func WithInstanceMetadata(md map[string]string) Option {
+	return optionFunc(func(srv *Server) {
+		srv.instanceMetadata = md
+	})
+}
+
+// WithMaxMessageSize limits the size of a request or stream message received
+// from a client. Larger unary requests are answered with an error, larger
+// stream messages reset the stream.
+func WithMaxMessageSize(n int) Option {
+	return optionFunc(func(srv *Server) {
+		srv.maxMessageSize = n
+	})
+}
+
+// WithMaxPendingRequests caps the unary requests handled at once per connection;
+// further requests fail with transport.ErrTooManyRequests until one finishes.
+func WithMaxPendingRequests(n int) Option {
+	return optionFunc(func(srv *Server) {
+		srv.maxPendingRequests = n
+	})
+}
+
+// WithKeepalive pings a client after interval without traffic and drops the
+// connection if it does not answer within timeout, so half-open connections
+// from vanished clients are released.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return optionFunc(func(srv *Server) {
+		srv.pingInterval = interval
+		srv.pingTimeout = timeout
+	})
+}
+
+// WithIdleTimeout closes connections that have had no call in flight for d.
+func WithIdleTimeout(d time.Duration) Option {
+	return optionFunc(func(srv *Server) {
+		srv.idleTimeout = d
+	})
+}
+
+// OnewayErrorHook is called when a one-way call fails. There is no response to
+// carry the error back, so it is only logged and reported here.
+type OnewayErrorHook func(serviceMethod string, err error)
+
+// WithOnewayErrorHook sets a hook for failed one-way calls.
+func WithOnewayErrorHook(hook OnewayErrorHook) Option {
+	return optionFunc(func(srv *Server) {
+		srv.onewayErrorHook = hook
+	})
+}
+
+// WithMetrics registers the server's Prometheus collectors (see package metrics)
+// with reg. If registration fails the error is logged and metrics stay disabled.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return optionFunc(func(srv *Server) {
+		srv.metricsReg = reg
+	})
+}
+
+// WithReflection registers the Reflection service, letting clients such as
+// cmd/xxrpc list the server's methods and their request schemas.
+func WithReflection() Option {
+	return optionFunc(func(srv *Server) {
+		srv.reflection = true
+	})
+}
+
 func NewServer(addr string, registry *registry.Registry, opts ...Option) *Server {
 	s := &Server{
 		addr:     addr,
 		registry: registry,
+		codec:    &codec.JsoniterCodec{},
+		logger:   zap.NewNop(),
+		peers:    make(map[*transport.Conn]*Peer),
+		done:     make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt.Apply(s)
 	}
 
+	if s.metricsReg != nil {
+		m, err := metrics.NewServer(s.metricsReg)
+		if err != nil {
+			s.logger.Error("failed to register metrics", zap.Error(err))
+		}
+		s.metrics = m
+	}
+
+	s.health = health.NewServer()
+	for _, name := range registry.Services() {
+		s.health.SetServingStatus(name, health.StatusServing)
+	}
+	s.registry.Register(s.health, s.codec)
+	if s.reflection {
+		s.registry.Register(&reflection.Server{}, s.codec)
+	}
 	return s
 }
 
@@ -54,90 +179,208 @@ type Server struct {
 	registry *registry.Registry
 
 	logger *zap.Logger
+
+	maxMessageSize     int
+	maxPendingRequests int
+	pingInterval       time.Duration
+	pingTimeout        time.Duration
+	idleTimeout        time.Duration
+
+	onewayErrorHook OnewayErrorHook
+	onConnect       func(*Peer)
+	onDisconnect    func(*Peer)
+
+	metricsReg prometheus.Registerer
+	metrics    *metrics.Metrics // nil unless WithMetrics succeeded
+	tracer     *tracing.Tracer  // nil unless WithTracerProvider is used
+	accessLog  *AccessLogConfig // nil unless WithAccessLog is used
+	logLevel   *zap.AtomicLevel // adjustable through the admin handler
+	health     *health.Server
+	reflection bool
+
+	registrar        discovery.Registrar
+	registerTTL      time.Duration
+	advertiseAddr    string
+	instanceWeight   int
+	instanceMetadata map[string]string
+	instances        []discovery.Instance
+	heartbeat        sync.WaitGroup // waited for before deregistering
+
+	mu       sync.Mutex
+	ln       net.Listener
+	peers    map[*transport.Conn]*Peer
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 func (s *Server) Register(service registry.Service) {
 	s.registry.Register(service, s.codec)
+	s.health.SetServingStatus(service.Name(), health.StatusServing)
 }
 
-func (s *Server) Invoke(req *protocol.Request, resp *protocol.Response) error {
-	handler, err := s.registry.Find(req.Method)
-	if err != nil {
-		resp.Error = err.Error()
-		return err
-	}
-
-	respData, err := handler(*req.Params)
-	if err != nil {
-		resp.Error = err.Error()
-		return nil
-	}
+// Health returns the server's Health service, registered with every server.
+// Services are reported SERVING once registered; use SetServingStatus on it to
+// take one out of rotation.
+func (s *Server) Health() *health.Server {
+	return s.health
+}
 
-	resp.Data = &respData
-	resp.Error = ""
-	return nil
+func (s *Server) Invoke(req *protocol.Request, resp *protocol.Response) error {
+	return s.registry.Invoke(req, resp)
 }
 
 func (s *Server) Logger() *zap.Logger {
 	return s.logger
 }
 
+// Start listens on the server's TCP address and serves it, see Serve.
 func (s *Server) Start() error {
 	ln, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		s.logger.Error("failed to start server", zap.Error(err))
 		return err
 	}
-	s.logger.Info("RPC Server listening", zap.String("address", s.addr))
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln, which may be a Unix socket, an inherited
+// socket or a memconn listener, until Stop is called. It always returns a
+// non-nil error: ErrServerClosed after Stop, or the error that stopped Accept.
+// Stop closes ln. Serve is meant to be called once per server.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	default:
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	s.logger.Info("RPC Server listening", zap.String("address", ln.Addr().String()))
+	if err := s.register(ln.Addr()); err != nil {
+		ln.Close()
+		return err
+	}
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Error("accept error", zap.Error(err))
 			continue
 		}
-		go s.handleConnV1(conn)
+		go s.ServeConn(conn)
 	}
 }
 
-func (s *Server) handleConnV1(conn net.Conn) {
-	fc := protocol.NewFrameConn(conn)
-	defer fc.Close()
+// Stop reports every service NOT_SERVING through the Health service,
+// deregisters the server from its registrar and closes the listener.
+// Connections that are already open are left to finish.
+func (s *Server) Stop() error {
+	var err error
+	s.stopOnce.Do(func() {
+		s.health.Shutdown()
 
-	for {
-		data, err := fc.ReadFrame()
-		if err != nil {
-			if err != io.EOF {
-				s.logger.Error("read frame error", zap.Error(err))
-			}
-			return
-		}
+		s.mu.Lock()
+		close(s.done)
+		ln := s.ln
+		s.mu.Unlock()
 
-		req := pool.GetRequest()
-		// 注意： req.Params 是 *[]byte 或 []byte，根据你的实现，这里假设 req.Params is *[]byte or pointer.
-		if err := s.codec.Unmarshal(data, req); err != nil {
-			s.logger.Error("decode request error", zap.Error(err))
-			pool.PutRequest(req)
-			continue
+		s.deregister()
+		if ln != nil {
+			err = ln.Close()
 		}
+	})
+	return err
+}
 
-		resp := pool.GetResponse()
-		if err := s.Invoke(req, resp); err != nil {
-			resp.Error = err.Error()
-		}
+// ServeConn serves a single connection until it is closed. Start calls it for
+// every accepted connection; it is exported for connections made elsewhere,
+// such as memconn pipes.
+func (s *Server) ServeConn(conn net.Conn) {
+	fc := protocol.NewFrameConn(conn)
+	tc := transport.NewConn(fc, s.codec, false, connHandler{s})
+	if s.maxMessageSize > 0 {
+		tc.MaxMessageSize = s.maxMessageSize
+	}
+	if s.maxPendingRequests > 0 {
+		tc.MaxPendingRequests = s.maxPendingRequests
+	}
+	tc.PingInterval = s.pingInterval
+	tc.PingTimeout = s.pingTimeout
+	tc.IdleTimeout = s.idleTimeout
+	if s.metrics != nil {
+		tc.OnReject = s.observeReject
+	}
+	defer tc.Close()
 
-		respData, err := s.codec.Marshal(resp)
-		if err != nil {
-			s.logger.Error("failed to encode response", zap.Error(err))
-		} else {
-			if err := fc.WriteFrame(respData); err != nil {
-				s.logger.Error("failed to write frame", zap.Error(err))
-				pool.PutRequest(req)
-				pool.PutResponse(resp)
-				return
-			}
-		}
+	p := s.addPeer(tc)
+	defer s.removePeer(p)
+	s.metrics.ConnOpened()
+	defer s.metrics.ConnClosed()
 
-		pool.PutRequest(req)
-		pool.PutResponse(resp)
-		// note: data slice is backed by fc buffer; do not retain it beyond this iteration
+	// errors after the connection was closed for another reason are not read errors
+	if err := tc.Serve(); err != nil && err != io.EOF && tc.Err() == err {
+		s.logger.Error("read frame error", zap.Error(err))
+		s.metrics.FrameError()
 	}
 }
+
+// connHandler serves the calls that arrive on one connection.
+type connHandler struct {
+	s *Server
+}
+
+func (h connHandler) ServeRequest(c *transport.Conn, req *protocol.Request, resp *protocol.Response) {
+	o := h.s.observe(c, req.Method, req.Metadata)
+	err := h.s.registry.InvokeContext(o.ctx, req, resp)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	o.done(statusCode(resp, err), size(req.Params), size(resp.Data), resp.Error)
+}
+
+func (h connHandler) ServeOneway(c *transport.Conn, req *protocol.Request) {
+	o := h.s.observe(c, req.Method, req.Metadata)
+	resp := protocol.Response{}
+	err := h.s.registry.InvokeContext(o.ctx, req, &resp)
+	o.done(statusCode(&resp, err), size(req.Params), -1, resp.Error)
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err == nil {
+		return
+	}
+
+	h.s.logger.Warn("oneway call failed", zap.String("method", req.Method), zap.Error(err))
+	if h.s.onewayErrorHook != nil {
+		h.s.onewayErrorHook(req.Method, err)
+	}
+}
+
+func (h connHandler) ServeStream(c *transport.Conn, st *transport.Stream) error {
+	o := h.s.observe(c, st.Method(), st.Metadata())
+	handler, err := h.s.registry.FindStream(st.Method())
+	if err != nil {
+		o.done(metrics.CodeNotFound, -1, -1, err.Error())
+		return err
+	}
+
+	st.SetContext(o.ctx)
+	if err = handler(st); err != nil {
+		o.done(metrics.CodeError, -1, -1, err.Error())
+		return err
+	}
+	o.done(metrics.CodeOK, -1, -1, "")
+	return nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"xxrpc/client"
+	"xxrpc/health"
+	"xxrpc/internal/codec"
+	"xxrpc/memconn"
+	"xxrpc/metrics"
+	"xxrpc/registry"
+)
+
+func TestServe(t *testing.T) {
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "xxrpc.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := memconn.Listen(0)
+	var d net.Dialer
+
+	for _, tc := range []struct {
+		name string
+		ln   net.Listener
+		dial func(context.Context, string) (net.Conn, error)
+	}{
+		{"unix", unix, func(ctx context.Context, addr string) (net.Conn, error) { return d.DialContext(ctx, "unix", addr) }},
+		{"memconn", mem, mem.DialContext},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewServer("", registry.NewRegister())
+			served := make(chan error, 1)
+			go func() { served <- s.Serve(tc.ln) }()
+
+			cli, err := client.Dial(tc.ln.Addr().String(), client.WithDialer(tc.dial))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			if status, err := cli.CheckHealth(""); err != nil || status != health.StatusServing {
+				t.Fatalf("CheckHealth = %s, %v", status, err)
+			}
+
+			s.Stop()
+			if err := <-served; !errors.Is(err, ErrServerClosed) {
+				t.Fatalf("Serve returned %v", err)
+			}
+		})
+	}
+}
+
+func TestHandlerMetadata(t *testing.T) {
+	r := registry.NewRegister()
+	r.ServiceMethods["Echo.User"] = registry.NewUnaryContextMethod(&codec.JsoniterCodec{}, func(ctx context.Context, _ *struct{}) (*string, error) {
+		user := registry.MetadataFromContext(ctx)["user"]
+		return &user, nil
+	})
+	r.ServiceMethods["Echo.Watch"] = &registry.ServiceMethod{StreamHandler: func(st registry.Stream) error {
+		return st.SendMsg(registry.MetadataFromContext(st.Context())["user"])
+	}}
+	s := NewServer("memconn", r)
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	md := client.WithMetadata(map[string]string{"user": "u1"})
+
+	resp, err := cli.Call("Echo.User", struct{}{}, md)
+	if err != nil || resp.Error != "" || string(*resp.Data) != `"u1"` {
+		t.Fatalf("unary handler saw %v, %v", resp, err)
+	}
+	st, err := cli.NewStream("Echo.Watch", md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	var user string
+	if err := st.RecvMsg(&user); err != nil || user != "u1" {
+		t.Fatalf("stream handler saw %q, %v", user, err)
+	}
+}
+
+func TestMetricsUnknownMethod(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r := registry.NewRegister()
+	r.ServiceMethods["Echo.Say"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) { return b, nil }}
+	s := NewServer("memconn", r, WithMetrics(reg))
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	for _, method := range []string{"Echo.Say", "Echo.Nope", "random-1", "random-2"} {
+		if _, err := cli.Call(method, "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := requestCounts(t, reg)
+	want := map[string]float64{
+		"Echo.Say/" + metrics.CodeOK:                       1,
+		metrics.MethodUnknown + "/" + metrics.CodeNotFound: 3,
+	}
+	checkCounts(t, counts, want)
+}
+
+func TestMetricsRejected(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r := registry.NewRegister()
+	started, release := make(chan struct{}), make(chan struct{})
+	r.ServiceMethods["Slow.Wait"] = &registry.ServiceMethod{Handler: func(b []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-release
+		return b, nil
+	}}
+	s := NewServer("memconn", r, WithMetrics(reg), WithMaxPendingRequests(1), WithMaxMessageSize(1024))
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	first := cli.Go("Slow.Wait", "hi", make(chan *client.Call, 1))
+	<-started
+	if resp, err := cli.Call("Slow.Wait", "hi"); err != nil || resp.Error == "" {
+		t.Fatalf("call over the pending cap = %+v, %v", resp, err)
+	}
+	close(release)
+	<-first.Done
+	if resp, err := cli.Call("Slow.Wait", strings.Repeat("x", 64*1024)); err != nil || resp.Error == "" {
+		t.Fatalf("call over the size limit = %+v, %v", resp, err)
+	}
+
+	checkCounts(t, requestCounts(t, reg), map[string]float64{
+		"Slow.Wait/" + metrics.CodeOK:                      1,
+		"Slow.Wait/" + metrics.CodeTooManyRequests:         1,
+		metrics.MethodUnknown + "/" + metrics.CodeTooLarge: 1,
+	})
+}
+
+// requestCounts returns xxrpc_server_requests_total by "method/code".
+func requestCounts(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "xxrpc_server_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var method, code string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "method":
+					method = l.GetValue()
+				case "code":
+					code = l.GetValue()
+				}
+			}
+			counts[method+"/"+code] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func checkCounts(t *testing.T, counts, want map[string]float64) {
+	t.Helper()
+	if len(counts) != len(want) {
+		t.Fatalf("requests_total = %v, want %v", counts, want)
+	}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Fatalf("requests_total = %v, want %v", counts, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package tracing creates OpenTelemetry spans for calls and carries the trace
+// context across the wire in request metadata as a W3C traceparent.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"xxrpc/metrics"
+)
+
+const instrumentationName = "xxrpc"
+
+// Span attribute keys beyond the OpenTelemetry RPC conventions.
+const (
+	AttrRequestSize  = attribute.Key("rpc.request.size")
+	AttrResponseSize = attribute.Key("rpc.response.size")
+	AttrStatusCode   = attribute.Key("rpc.xxrpc.status_code")
+)
+
+// Tracer starts client and server spans. All methods are no-ops on a nil
+// *Tracer, so callers need not check whether tracing is enabled.
+type Tracer struct {
+	tracer trace.Tracer
+	prop   propagation.TextMapPropagator
+}
+
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer: tp.Tracer(instrumentationName),
+		prop:   propagation.TraceContext{},
+	}
+}
+
+// StartClient starts a client span for a call to method as a child of ctx.
+// It returns a copy of md carrying the span's traceparent.
+func (t *Tracer) StartClient(ctx context.Context, method, peer string, md map[string]string) (*Span, map[string]string) {
+	if t == nil {
+		return nil, md
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := t.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(callAttributes(method, peer)...))
+	return &Span{span: span}, t.Inject(ctx, md)
+}
+
+// Inject returns a copy of md carrying the trace context of ctx, without
+// starting a span. md is returned unchanged if ctx has no valid span.
+func (t *Tracer) Inject(ctx context.Context, md map[string]string) map[string]string {
+	if t == nil || ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return md
+	}
+	carrier := make(propagation.MapCarrier, len(md)+1)
+	for k, v := range md {
+		carrier[k] = v
+	}
+	t.prop.Inject(ctx, carrier)
+	return carrier
+}
+
+// StartServer starts a server span for a call received with md, continuing
+// the caller's trace if md carries a traceparent. The returned context carries
+// the span, so calls made by the handler continue the trace; it is
+// context.Background on a nil *Tracer.
+func (t *Tracer) StartServer(method, peer string, md map[string]string) (context.Context, *Span) {
+	if t == nil {
+		return context.Background(), nil
+	}
+	ctx := t.prop.Extract(context.Background(), propagation.MapCarrier(md))
+	ctx, span := t.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(callAttributes(method, peer)...))
+	return ctx, &Span{span: span}
+}
+
+func callAttributes(method, peer string) []attribute.KeyValue {
+	service, name := method, ""
+	if i := strings.LastIndexByte(method, '.'); i >= 0 {
+		service, name = method[:i], method[i+1:]
+	}
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", instrumentationName),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", name),
+		attribute.String("network.peer.address", peer),
+	}
+}
+
+// TraceID returns the trace ID carried by md's traceparent, or "" if there is none.
+func TraceID(md map[string]string) string {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(md))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Span is one call's span.
+type Span struct {
+	span trace.Span
+}
+
+// TraceID returns the ID of the span's trace.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+// End records the outcome and ends the span. code is one of the metrics.Code*
+// values; any code but ok marks the span as failed with errMsg. Negative sizes
+// are not recorded.
+func (s *Span) End(code string, reqBytes, respBytes int, errMsg string) {
+	if s == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{AttrStatusCode.String(code)}
+	if reqBytes >= 0 {
+		attrs = append(attrs, AttrRequestSize.Int(reqBytes))
+	}
+	if respBytes >= 0 {
+		attrs = append(attrs, AttrResponseSize.Int(respBytes))
+	}
+	s.span.SetAttributes(attrs...)
+	if code != metrics.CodeOK {
+		s.span.SetStatus(codes.Error, errMsg)
+	}
+	s.span.End()
+}
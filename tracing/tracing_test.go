@@ -0,0 +1,123 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"xxrpc/client"
+	"xxrpc/internal/codec"
+	"xxrpc/memconn"
+	"xxrpc/metrics"
+	"xxrpc/registry"
+	"xxrpc/server"
+	"xxrpc/tracing"
+)
+
+type sayReq struct {
+	Message string
+}
+
+type sayResp struct {
+	Message string
+	User    string
+}
+
+// serve starts a traced server with the methods of reg and returns its listener.
+func serve(t *testing.T, tp trace.TracerProvider, reg *registry.Registry) *memconn.Listener {
+	s := server.NewServer("memconn", reg, server.WithTracerProvider(tp))
+	ln := memconn.Listen(0)
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Stop() })
+	return ln
+}
+
+func dial(t *testing.T, tp trace.TracerProvider, ln *memconn.Listener) *client.Client {
+	cli, err := client.Dial("memconn", client.WithDialer(ln.DialContext), client.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// TestPropagation follows one call from a client through a front server, whose
+// handler calls a back server with the context it was given.
+func TestPropagation(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	c := &codec.JsoniterCodec{}
+
+	back := registry.NewRegister()
+	back.ServiceMethods["Back.Say"] = registry.NewUnaryContextMethod(c, func(ctx context.Context, req *sayReq) (*sayResp, error) {
+		return nil, errors.New("boom")
+	})
+	backCli := dial(t, tp, serve(t, tp, back))
+
+	front := registry.NewRegister()
+	front.ServiceMethods["Front.Say"] = registry.NewUnaryContextMethod(c, func(ctx context.Context, req *sayReq) (*sayResp, error) {
+		if _, err := backCli.Call("Back.Say", req, client.WithContext(ctx)); err != nil {
+			return nil, err
+		}
+		return &sayResp{Message: req.Message, User: registry.MetadataFromContext(ctx)["user"]}, nil
+	})
+	cli := dial(t, tp, serve(t, tp, front))
+
+	resp, err := cli.Call("Front.Say", &sayReq{Message: "hi"}, client.WithMetadata(map[string]string{"user": "u1"}))
+	if err != nil || resp.Error != "" {
+		t.Fatalf("call: %v, %q", err, resp.Error)
+	}
+	var out sayResp
+	if err := c.Unmarshal(*resp.Data, &out); err != nil || out.User != "u1" {
+		t.Fatalf("handler saw metadata %+v, %v", out, err)
+	}
+
+	// spans end innermost first
+	spans := exp.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	backSrv, backCl, frontSrv, frontCl := spans[0], spans[1], spans[2], spans[3]
+	for _, sp := range []struct {
+		span tracetest.SpanStub
+		name string
+		kind trace.SpanKind
+	}{
+		{backSrv, "Back.Say", trace.SpanKindServer},
+		{backCl, "Back.Say", trace.SpanKindClient},
+		{frontSrv, "Front.Say", trace.SpanKindServer},
+		{frontCl, "Front.Say", trace.SpanKindClient},
+	} {
+		if sp.span.Name != sp.name || sp.span.SpanKind != sp.kind {
+			t.Fatalf("span %s %v, want %s %v", sp.span.Name, sp.span.SpanKind, sp.name, sp.kind)
+		}
+		if sp.span.SpanContext.TraceID() != frontCl.SpanContext.TraceID() {
+			t.Fatalf("span %s %v is not in the client's trace", sp.span.Name, sp.span.SpanKind)
+		}
+	}
+	for _, link := range []struct{ child, parent tracetest.SpanStub }{
+		{frontSrv, frontCl}, {backCl, frontSrv}, {backSrv, backCl},
+	} {
+		if link.child.Parent.SpanID() != link.parent.SpanContext.SpanID() {
+			t.Fatalf("%s %v is not a child of %s %v", link.child.Name, link.child.SpanKind, link.parent.Name, link.parent.SpanKind)
+		}
+	}
+
+	// the back server's error fails its own span; the front call succeeded
+	if backSrv.Status.Code != codes.Error || backSrv.Status.Description != "boom" || frontCl.Status.Code == codes.Error {
+		t.Fatalf("status = %v, %v", backSrv.Status, frontCl.Status)
+	}
+	attrs := map[string]string{}
+	for _, kv := range frontCl.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["rpc.service"] != "Front" || attrs["rpc.method"] != "Say" ||
+		attrs["network.peer.address"] != "memconn" || attrs[string(tracing.AttrStatusCode)] != metrics.CodeOK {
+		t.Fatalf("attributes = %v", attrs)
+	}
+}